@@ -0,0 +1,157 @@
+// Package apnshttp exposes an apns client over a small HTTP API, so that
+// services not written in Go can push through one managed connection.
+//
+//	POST /push
+//	{"token": "<hex device token>", "payload": {"aps": {...}}, "expiration": 3600, "priority": 10}
+//
+// Invalid requests, including payloads over the client's limit, are
+// answered with 400 Bad Request, failed sends with 502 Bad Gateway.
+package apnshttp
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/Mistobaan/go-apns"
+)
+
+// Sender is the part of *apns.ApnsConn used by the gateway.
+type Sender = apns.Sender
+
+// MAX_REQUEST_SIZE bounds the request body, well above the largest
+// payload.
+const MAX_REQUEST_SIZE = 64 << 10
+
+// PushRequest is the JSON body accepted by the handler.
+// Expiration is expressed in seconds from now, Priority is 5 or 10 (0 for
+// Apple's default).
+type PushRequest struct {
+	Token      string          `json:"token"`
+	Payload    json.RawMessage `json:"payload"`
+	Expiration int64           `json:"expiration"`
+	Priority   int             `json:"priority"`
+}
+
+type pushResponse struct {
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+	Identifier uint32 `json:"identifier,omitempty"`
+	Reason     string `json:"reason,omitempty"` // APNs status text when rejected
+}
+
+// Handler forwards each POSTed PushRequest to Client.
+type Handler struct {
+	Client Sender
+}
+
+// NewHandler creates a Handler sending through client.
+func NewHandler(client Sender) *Handler {
+	return &Handler{Client: client}
+}
+
+func (req *PushRequest) validate() error {
+	if req.Token == "" {
+		return errors.New("Missing device token")
+	}
+	if len(req.Payload) == 0 {
+		return errors.New("Missing payload")
+	}
+	if req.Expiration < 0 {
+		return errors.New("Expiration must not be negative")
+	}
+	switch req.Priority {
+	case 0, 5, 10:
+	default:
+		return errors.New("Priority must be 5 or 10")
+	}
+	return nil
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		writeJSON(w, http.StatusMethodNotAllowed, pushResponse{Status: "error", Error: "Method not allowed"})
+		return
+	}
+
+	var req PushRequest
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, MAX_REQUEST_SIZE)).Decode(&req)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeJSON(w, http.StatusRequestEntityTooLarge, pushResponse{Status: "error", Error: "Request body too large"})
+		return
+	}
+
+	n := &apns.Notification{
+		DeviceToken: req.Token,
+		Payload:     req.Payload,
+		Expiration:  time.Duration(req.Expiration) * time.Second,
+		Priority:    uint8(req.Priority),
+	}
+	if err == nil {
+		err = req.validate()
+	}
+	if err == nil {
+		err = n.Validate()
+	}
+	if err == nil {
+		err = h.checkSize(n)
+	}
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, pushResponse{Status: "error", Error: err.Error()})
+		return
+	}
+
+	resp, err := h.Client.SendContext(r.Context(), n)
+	var payloadErr *apns.PayloadTooLargeError
+	if errors.As(err, &payloadErr) {
+		writeJSON(w, http.StatusBadRequest, pushResponse{Status: "error", Error: err.Error()})
+		return
+	}
+
+	body := pushResponse{Status: "ok"}
+	if resp != nil {
+		body.Identifier = resp.Identifier
+		if !resp.Accepted() {
+			body.Reason = resp.Reason()
+		}
+	}
+	if err != nil {
+		body.Status = "error"
+		body.Error = err.Error()
+		writeJSON(w, http.StatusBadGateway, body)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, body)
+}
+
+// checkSize rejects payloads over the limit of the client, when it has
+// one like *apns.ApnsConn.
+func (h *Handler) checkSize(n *apns.Notification) error {
+	c, ok := h.Client.(interface{ MaxPayloadSize() int })
+	if ok && len(n.Payload) > c.MaxPayloadSize() {
+		return &apns.PayloadTooLargeError{Size: len(n.Payload), Limit: c.MaxPayloadSize()}
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// NewServeMux returns a mux serving the gateway on /push.
+func NewServeMux(client Sender) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/push", NewHandler(client))
+	return mux
+}
+
+// ListenAndServe runs the gateway on addr until the server fails.
+func ListenAndServe(addr string, client Sender) error {
+	return http.ListenAndServe(addr, NewServeMux(client))
+}
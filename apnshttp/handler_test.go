@@ -0,0 +1,109 @@
+package apnshttp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Mistobaan/go-apns"
+)
+
+type fakeSender struct {
+	token      string
+	payload    string
+	expiration time.Duration
+	priority   uint8
+	err        error
+}
+
+func (s *fakeSender) SendContext(ctx context.Context, n *apns.Notification) (*apns.Response, error) {
+	s.token = n.DeviceToken
+	s.payload = string(n.Payload)
+	s.expiration = n.Expiration
+	s.priority = n.Priority
+	if s.err != nil {
+		return &apns.Response{Identifier: 1, Status: apns.StatusInvalidToken}, s.err
+	}
+	return &apns.Response{Identifier: 1, Status: apns.StatusNoErrors}, nil
+}
+
+func (s *fakeSender) MaxPayloadSize() int {
+	return apns.MAX_PAYLOAD_SIZE
+}
+
+// token is a valid 32 bytes device token.
+var token = strings.Repeat("0a", apns.DEVICE_TOKEN_SIZE)
+
+func post(h http.Handler, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("POST", "/push", strings.NewReader(body)))
+	return rec
+}
+
+func Test_Handler(t *testing.T) {
+	sender := &fakeSender{}
+	h := NewServeMux(sender)
+
+	rec := post(h, `{"token": "`+token+`", "payload": {"aps": {"alert": "hi"}}, "expiration": 60, "priority": 10}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Unexpected status %d: %s", rec.Code, rec.Body.String())
+	}
+	if sender.token != token || sender.payload != `{"aps": {"alert": "hi"}}` || sender.expiration != time.Minute || sender.priority != 10 {
+		t.Errorf("Push not forwarded correctly: %+v", sender)
+	}
+
+	rec = post(h, `{"payload": {}}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Missing token accepted: %d", rec.Code)
+	}
+
+	rec = post(h, `{"token": "`+token+`", "payload": {}, "priority": 7}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Invalid priority accepted: %d", rec.Code)
+	}
+
+	sender.err = errors.New("Invalid Token")
+	rec = post(h, `{"token": "`+token+`", "payload": {}}`)
+	if rec.Code != http.StatusBadGateway || !strings.Contains(rec.Body.String(), `"reason":"Invalid Token"`) {
+		t.Errorf("Send error not reported: %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/push", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET accepted: %d", rec.Code)
+	}
+}
+
+func Test_HandlerBadRequest(t *testing.T) {
+	sender := &fakeSender{}
+	h := NewHandler(sender)
+	large := strings.Repeat("x", apns.MAX_PAYLOAD_SIZE)
+
+	for _, body := range []string{
+		`{"token": "not hex", "payload": {}}`,
+		`{"token": "` + token + `", "payload": {"aps": {"content-available": 1}}}`,
+		`{"token": "` + token + `", "payload": {"aps": {"alert": "` + large + `"}}}`,
+	} {
+		rec := post(h, body)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %.60s, got %d %s", body, rec.Code, rec.Body.String())
+		}
+	}
+	if sender.token != "" {
+		t.Errorf("Invalid request was sent: %+v", sender)
+	}
+
+	sender.err = &apns.PayloadTooLargeError{Size: 3000, Limit: apns.MAX_PAYLOAD_SIZE}
+	if rec := post(h, `{"token": "`+token+`", "payload": {}}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a payload refused by the client, got %d", rec.Code)
+	}
+
+	if rec := post(h, `{"token": "`+strings.Repeat("x", MAX_REQUEST_SIZE)+`"}`); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413 for a large body, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,68 @@
+package apnstest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Mistobaan/go-apns"
+)
+
+func benchmarkSend(b *testing.B, readTimeout time.Duration) {
+	server := NewServer()
+	server.Discard()
+	client := &apns.ApnsConn{Transport: server.Transport(), ReadTimeout: readTimeout}
+	n := &apns.Notification{DeviceToken: Token, Payload: badgePayload(1)}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := client.Send(n)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkSend measures the synchronous path, dominated by the wait for
+// an error response.
+func BenchmarkSend(b *testing.B) {
+	benchmarkSend(b, time.Millisecond)
+}
+
+// BenchmarkSendNoReadWait measures writing notifications back to back.
+func BenchmarkSendNoReadWait(b *testing.B) {
+	benchmarkSend(b, apns.NO_READ_WAIT)
+}
+
+// BenchmarkSendPipelined measures the pipelined mode, which keeps every
+// notification for resending.
+func BenchmarkSendPipelined(b *testing.B) {
+	server := NewServer()
+	server.Discard()
+	client := &apns.ApnsConn{Transport: server.Transport(), ReadTimeout: time.Millisecond, Pipelined: true}
+	n := &apns.Notification{DeviceToken: Token, Payload: badgePayload(1)}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := client.Send(n)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSendAsync(b *testing.B) {
+	server := NewServer()
+	server.Discard()
+	client := &apns.ApnsConn{Transport: server.Transport(), ReadTimeout: apns.NO_READ_WAIT}
+	n := apns.Notification{DeviceToken: Token, Payload: badgePayload(1)}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	var last *apns.Future
+	for i := 0; i < b.N; i++ {
+		last = client.SendAsync(n)
+	}
+	<-last.Done()
+}
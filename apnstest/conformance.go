@@ -0,0 +1,200 @@
+package apnstest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Mistobaan/go-apns"
+)
+
+// Token is a well formed device token for tests.
+const Token = "0a0b0c0d0e0f101112131415161718191a1b1c1d1e1f202122232425262728292a"
+
+// RejectedToken is a device token the suite makes the server reject.
+const RejectedToken = "deadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeef"
+
+// RunSenderConformance checks that an apns.Sender honours the contract of
+// *apns.ApnsConn: notifications arrive in order, invalid ones are refused
+// locally without reaching the gateway, rejections are reported as
+// *apns.StatusError without poisoning later sends, a gateway shutdown is
+// retried or reported as retryable, and cancelled contexts stop sends
+// before the gateway. A sender reading error responses in the background
+// may return before the rejection arrives: it must then report it on its
+// Errors channel, like *apns.ApnsConn with BackgroundReader. newSender is
+// called once per subtest and must route its connections through
+// server.Transport().
+func RunSenderConformance(t *testing.T, newSender func(server *Server) apns.Sender) {
+	t.Run("Ordering", func(t *testing.T) {
+		server := NewServer()
+		sender := newSender(server)
+
+		for i := 0; i < 20; i++ {
+			resp, err := sender.SendContext(context.Background(), &apns.Notification{DeviceToken: Token, Payload: badgePayload(i)})
+			if err != nil {
+				t.Fatalf("Send %d failed: %v", i, err)
+			}
+			if resp == nil || !resp.Accepted() {
+				t.Errorf("Send %d not reported as accepted: %+v", i, resp)
+			}
+		}
+
+		received := waitReceived(server, 20)
+		if len(received) != 20 {
+			t.Fatalf("Server received %d notifications, want 20", len(received))
+		}
+		for i, n := range received {
+			if string(n.Payload) != string(badgePayload(i)) || n.DeviceToken != Token {
+				t.Errorf("Notification %d out of order: %s", i, n.Payload)
+			}
+		}
+	})
+
+	t.Run("Validation", func(t *testing.T) {
+		server := NewServer()
+		sender := newSender(server)
+
+		invalid := map[string]*apns.Notification{
+			"non hex token":     {DeviceToken: "not a token", Payload: badgePayload(1)},
+			"oversized payload": {DeviceToken: Token, Payload: []byte(`{"aps":{"alert":"` + strings.Repeat("x", 64*1024) + `"}}`)},
+			"unknown priority":  {DeviceToken: Token, Payload: badgePayload(1), Priority: 1},
+			"silent immediate":  {DeviceToken: Token, Payload: []byte(`{"aps":{"content-available":1}}`), Priority: apns.PRIORITY_IMMEDIATE},
+		}
+		for name, n := range invalid {
+			if _, err := sender.SendContext(context.Background(), n); err == nil {
+				t.Errorf("Invalid notification accepted: %s", name)
+			}
+		}
+
+		if received := server.Received(); len(received) != 0 {
+			t.Errorf("Invalid notifications reached the gateway: %d", len(received))
+		}
+	})
+
+	t.Run("Rejection", func(t *testing.T) {
+		server := NewServer()
+		server.RejectWith(func(n Received) apns.Status {
+			if n.DeviceToken == RejectedToken {
+				return apns.StatusInvalidToken
+			}
+			return apns.StatusNoErrors
+		})
+		sender := newSender(server)
+		var errs <-chan *apns.PushError
+		if reporter, ok := sender.(errorReporter); ok {
+			errs = reporter.Errors()
+		}
+
+		resp, err := sender.SendContext(context.Background(), &apns.Notification{DeviceToken: RejectedToken, Payload: badgePayload(1)})
+		if err == nil && errs != nil {
+			// read in the background, after the send returned
+			select {
+			case e := <-errs:
+				err = e
+			case <-time.After(waitTimeout):
+			}
+		} else if resp == nil || resp.Status != apns.StatusInvalidToken {
+			t.Errorf("Rejection not reported in the Response: %+v", resp)
+		}
+		var statusErr *apns.StatusError
+		if !errors.As(err, &statusErr) || !statusErr.Status.IsTokenInvalid() {
+			t.Errorf("Expected an invalid token StatusError, got %v", err)
+		}
+
+		_, err = sender.SendContext(context.Background(), &apns.Notification{DeviceToken: Token, Payload: badgePayload(2)})
+		if err != nil {
+			t.Errorf("Send after a rejection failed: %v", err)
+		}
+		if received := waitReceived(server, 1); len(received) != 1 || received[0].DeviceToken != Token {
+			t.Errorf("Unexpected notifications after a rejection: %+v", received)
+		}
+	})
+
+	t.Run("Retry", func(t *testing.T) {
+		server := NewServer()
+		shutdown := true
+		server.RejectWith(func(n Received) apns.Status {
+			if shutdown {
+				shutdown = false
+				return apns.StatusShutdown
+			}
+			return apns.StatusNoErrors
+		})
+		sender := newSender(server)
+
+		// the sender may retry on its own, or leave it to the caller
+		resp, err := sender.SendContext(context.Background(), &apns.Notification{DeviceToken: Token, Payload: badgePayload(1)})
+		if err != nil && !apns.IsRetryable(resp, err) {
+			t.Errorf("Gateway shutdown reported as final: %v", err)
+		}
+
+		_, err = sender.SendContext(context.Background(), &apns.Notification{DeviceToken: Token, Payload: badgePayload(2)})
+		if err != nil {
+			t.Errorf("Send after a gateway shutdown failed: %v", err)
+		}
+		received := waitReceived(server, 1)
+		if len(received) == 0 || string(received[len(received)-1].Payload) != string(badgePayload(2)) {
+			t.Errorf("Send after a gateway shutdown not delivered: %+v", received)
+		}
+		if conns := server.Connections(); conns < 2 {
+			t.Errorf("Expected a new connection after the shutdown, got %d", conns)
+		}
+	})
+
+	t.Run("Cancellation", func(t *testing.T) {
+		server := NewServer()
+		sender := newSender(server)
+
+		cancelled, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := sender.SendContext(cancelled, &apns.Notification{DeviceToken: Token, Payload: badgePayload(1)})
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected context.Canceled, got %v", err)
+		}
+
+		expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+		defer cancel()
+		_, err = sender.SendContext(expired, &apns.Notification{DeviceToken: Token, Payload: badgePayload(2)})
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+		}
+
+		if received := server.Received(); len(received) != 0 {
+			t.Errorf("Cancelled notifications reached the gateway: %d", len(received))
+		}
+		_, err = sender.SendContext(context.Background(), &apns.Notification{DeviceToken: Token, Payload: badgePayload(3)})
+		if err != nil {
+			t.Errorf("Send after a cancellation failed: %v", err)
+		}
+	})
+}
+
+// errorReporter is implemented by senders reporting failures
+// asynchronously, like *apns.ApnsConn.
+type errorReporter interface {
+	Errors() <-chan *apns.PushError
+}
+
+// waitTimeout bounds the wait for what a sender does in the background.
+const waitTimeout = 2 * time.Second
+
+// waitReceived returns the notifications received by server once there
+// are at least n of them, or after waitTimeout: a send may return before
+// the server decoded the notification.
+func waitReceived(server *Server, n int) []Received {
+	deadline := time.Now().Add(waitTimeout)
+	for {
+		received := server.Received()
+		if len(received) >= n || time.Now().After(deadline) {
+			return received
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func badgePayload(badge int) []byte {
+	return []byte(fmt.Sprintf(`{"aps":{"badge":%d}}`, badge))
+}
@@ -0,0 +1,37 @@
+package apnstest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Mistobaan/go-apns"
+)
+
+func Test_ApnsConnConformance(t *testing.T) {
+	RunSenderConformance(t, func(server *Server) apns.Sender {
+		return &apns.ApnsConn{
+			Transport:   server.Transport(),
+			ReadTimeout: 50 * time.Millisecond,
+		}
+	})
+}
+
+func Test_ApnsConnConformanceBackgroundReader(t *testing.T) {
+	RunSenderConformance(t, func(server *Server) apns.Sender {
+		return &apns.ApnsConn{
+			Transport:        server.Transport(),
+			ReadTimeout:      50 * time.Millisecond,
+			BackgroundReader: true,
+		}
+	})
+}
+
+func Test_ApnsConnConformancePipelined(t *testing.T) {
+	RunSenderConformance(t, func(server *Server) apns.Sender {
+		return &apns.ApnsConn{
+			Transport:   server.Transport(),
+			ReadTimeout: 50 * time.Millisecond,
+			Pipelined:   true,
+		}
+	})
+}
@@ -0,0 +1,218 @@
+// Package apnstest provides an in-memory APNs binary gateway and a
+// conformance suite for code sending through it.
+package apnstest
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/Mistobaan/go-apns"
+)
+
+// Received is a notification decoded by the Server.
+type Received struct {
+	Command     uint8
+	Identifier  uint32
+	DeviceToken string // hex encoded
+	Payload     []byte
+	Expiration  uint32
+	Priority    uint8
+}
+
+// Server is an in-memory binary gateway. It decodes command 0, 1 and 2
+// frames and, like Apple, answers a rejected notification with an error
+// response before closing the connection.
+type Server struct {
+	mu       sync.Mutex
+	received []Received
+	reject   func(Received) apns.Status
+	conns    int
+	count    int
+	discard  bool
+}
+
+func NewServer() *Server {
+	return &Server{}
+}
+
+// Transport returns a Transport whose connections are served by s.
+func (s *Server) Transport() apns.Transport {
+	return apns.TransportFunc(func(endpoint string, config *tls.Config) (net.Conn, error) {
+		client, server := net.Pipe()
+		s.mu.Lock()
+		s.conns++
+		s.mu.Unlock()
+		go s.serve(server)
+		return client, nil
+	})
+}
+
+// RejectWith makes the server answer every notification for which reject
+// returns a status other than StatusNoErrors with an error response.
+func (s *Server) RejectWith(reject func(Received) apns.Status) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reject = reject
+}
+
+// Received returns the notifications accepted so far, in arrival order.
+func (s *Server) Received() []Received {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Received(nil), s.received...)
+}
+
+// Discard makes the server only count accepted notifications, keeping
+// memory flat under load.
+func (s *Server) Discard() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.discard = true
+	s.received = nil
+}
+
+// Count returns how many notifications were accepted, discarded or not.
+func (s *Server) Count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.count
+}
+
+// Connections returns how many connections were opened.
+func (s *Server) Connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conns
+}
+
+func (s *Server) serve(conn net.Conn) {
+	defer conn.Close()
+
+	for {
+		n, err := readNotification(conn)
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		status := apns.StatusNoErrors
+		if s.reject != nil {
+			status = s.reject(n)
+		}
+		if status == apns.StatusNoErrors {
+			s.count++
+			if !s.discard {
+				s.received = append(s.received, n)
+			}
+		}
+		s.mu.Unlock()
+
+		if status != apns.StatusNoErrors {
+			resp := []byte{8, byte(status), 0, 0, 0, 0}
+			binary.BigEndian.PutUint32(resp[2:], n.Identifier)
+			conn.Write(resp)
+			return
+		}
+	}
+}
+
+func readNotification(r io.Reader) (n Received, err error) {
+	err = binary.Read(r, binary.BigEndian, &n.Command)
+	if err != nil {
+		return
+	}
+
+	switch n.Command {
+	case 0, 1:
+		if n.Command == 1 {
+			err = binary.Read(r, binary.BigEndian, &n.Identifier)
+			if err != nil {
+				return
+			}
+			err = binary.Read(r, binary.BigEndian, &n.Expiration)
+			if err != nil {
+				return
+			}
+		}
+		var token, payload []byte
+		token, err = readItem(r)
+		if err != nil {
+			return
+		}
+		payload, err = readItem(r)
+		if err != nil {
+			return
+		}
+		n.DeviceToken = hex.EncodeToString(token)
+		n.Payload = payload
+	case 2:
+		var length uint32
+		err = binary.Read(r, binary.BigEndian, &length)
+		if err != nil {
+			return
+		}
+		frame := make([]byte, length)
+		_, err = io.ReadFull(r, frame)
+		if err != nil {
+			return
+		}
+		err = decodeFrame(frame, &n)
+	default:
+		err = errors.New("apnstest: unknown command")
+	}
+	return
+}
+
+func readItem(r io.Reader) ([]byte, error) {
+	var size uint16
+	err := binary.Read(r, binary.BigEndian, &size)
+	if err != nil {
+		return nil, err
+	}
+	item := make([]byte, size)
+	_, err = io.ReadFull(r, item)
+	return item, err
+}
+
+func decodeFrame(frame []byte, n *Received) error {
+	r := bytes.NewReader(frame)
+	for r.Len() > 0 {
+		var id uint8
+		err := binary.Read(r, binary.BigEndian, &id)
+		if err != nil {
+			return err
+		}
+		data, err := readItem(r)
+		if err != nil {
+			return err
+		}
+
+		switch id {
+		case 1:
+			n.DeviceToken = hex.EncodeToString(data)
+		case 2:
+			n.Payload = data
+		case 3, 4:
+			if len(data) != 4 {
+				return errors.New("apnstest: invalid item size")
+			}
+			if id == 3 {
+				n.Identifier = binary.BigEndian.Uint32(data)
+			} else {
+				n.Expiration = binary.BigEndian.Uint32(data)
+			}
+		case 5:
+			if len(data) != 1 {
+				return errors.New("apnstest: invalid item size")
+			}
+			n.Priority = data[0]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,440 @@
+package apns
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SEND_QUEUE_SIZE is the default of ApnsConn.MaxQueued.
+const SEND_QUEUE_SIZE = 10000
+
+// ErrQueueFull fails SendAsync notifications queued beyond MaxQueued.
+var ErrQueueFull = errors.New("Send queue is full")
+
+// ErrIdentifierInUse fails a SendAsync notification whose Identifier is
+// the one of an earlier notification still waiting for the background
+// reader: an error response could not tell them apart.
+var ErrIdentifierInUse = errors.New("Identifier of a notification still settling")
+
+// ErrCollapsed fails a SendAsync notification replaced by a newer one
+// with the same device token and CollapseID before it was sent.
+var ErrCollapsed = errors.New("Notification replaced by a newer one with the same CollapseID")
+
+// ErrShed fails the SendAsync notifications dropped to stay within
+// MaxQueuedBytes.
+var ErrShed = errors.New("Notification shed to stay within MaxQueuedBytes")
+
+// ErrSnapshotted fails the SendAsync notifications taken off the queue by
+// SnapshotQueue.
+var ErrSnapshotted = errors.New("Notification handed off by SnapshotQueue")
+
+// Future is the outcome of a SendAsync, available once Done is closed.
+type Future struct {
+	n        Notification
+	callback DeliveryCallback
+	done     chan struct{}
+	resp     *Response
+	err      error
+	settle   *time.Timer // resolves it as accepted, see sendFuture
+}
+
+// size is what f counts against MaxQueuedBytes.
+func (f *Future) size() int {
+	return len(f.n.DeviceToken)/2 + len(f.n.Payload)
+}
+
+// priority orders futures for shedding, Apple's default being immediate.
+func (f *Future) priority() uint8 {
+	if f.n.Priority == 0 {
+		return PRIORITY_IMMEDIATE
+	}
+	return f.n.Priority
+}
+
+// Done is closed once the notification was sent or failed.
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Wait returns the result of the send, like Send, or ctx.Err() if ctx is
+// done first. The notification is still sent in that case.
+func (f *Future) Wait(ctx context.Context) (*Response, error) {
+	select {
+	case <-f.done:
+		return f.resp, f.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (f *Future) resolve(resp *Response, err error) {
+	f.resp, f.err = resp, err
+	close(f.done)
+	if f.callback != nil {
+		f.callback(f.n, resp, err)
+	}
+}
+
+// DeliveryCallback receives the result of a notification sent with
+// SendCallback: the same Response and error Send would have returned.
+type DeliveryCallback func(n Notification, resp *Response, err error)
+
+// sendQueue holds the futures waiting for the SendAsync worker, which
+// runs while the queue is not empty.
+type sendQueue struct {
+	mu       sync.Mutex
+	pending  []*Future
+	running  bool
+	queued   int                // futures not sent yet
+	bytes    int                // their size, see MaxQueuedBytes
+	settling map[uint32]*Future // written, waiting for the background reader
+	changed  chan struct{}      // closed when the worker stops or a future settles
+}
+
+// SendAsync queues n and returns at once. Queued notifications are sent
+// in order by a background goroutine, with the same connection and error
+// handling as Send; the Future resolves when the gateway accepted or
+// rejected the notification. With a background reader the worker does not
+// wait for each answer: the Future resolves with the error response
+// naming the notification, or as accepted once the settle time passed
+// without one. Notifications queued beyond MaxQueued fail at once with
+// ErrQueueFull, those queued once Close was called with ErrClientClosed;
+// Close sends the ones queued before it.
+func (client *ApnsConn) SendAsync(n Notification) *Future {
+	return client.enqueue(&Future{n: n, done: make(chan struct{})})
+}
+
+// SendCallback is SendAsync for fire-and-forget code: callback is called
+// with the result from the sending goroutine, from the caller's when the
+// queue is full, and with a background reader from the reader or a timer
+// goroutine. It must not block and must not wait for other notifications
+// of the same client, but may send again, e.g. a dropped notification.
+func (client *ApnsConn) SendCallback(n Notification, callback DeliveryCallback) {
+	client.enqueue(&Future{n: n, callback: callback, done: make(chan struct{})})
+}
+
+func (client *ApnsConn) enqueue(f *Future) *Future {
+	max := client.MaxQueued
+	if max <= 0 {
+		max = SEND_QUEUE_SIZE
+	}
+
+	q := &client.queue
+	q.mu.Lock()
+	if atomic.LoadInt32(&client.closing) != 0 {
+		q.mu.Unlock()
+		f.resolve(nil, ErrClientClosed)
+		return f
+	}
+	collapsed := q.collapse(f)
+	if q.queued >= max {
+		q.mu.Unlock()
+		f.resolve(nil, ErrQueueFull)
+		return f
+	}
+	shed, fits := q.shed(f, client.MaxQueuedBytes)
+	if fits {
+		q.pending = append(q.pending, f)
+		q.queued++
+		q.bytes += f.size()
+		if !q.running {
+			q.running = true
+			go client.runQueue()
+		}
+	} else {
+		shed = append(shed, f)
+	}
+	q.mu.Unlock()
+
+	if collapsed != nil {
+		collapsed.resolve(nil, ErrCollapsed)
+	}
+	for _, s := range shed {
+		s.resolve(nil, ErrShed)
+		if client.OnShed != nil {
+			client.OnShed(s.n)
+		}
+	}
+	return f
+}
+
+// collapse takes off the pending future f replaces, if any. q must be
+// locked.
+func (q *sendQueue) collapse(f *Future) *Future {
+	if f.n.CollapseID == "" {
+		return nil
+	}
+	for i, p := range q.pending {
+		if p.n.CollapseID == f.n.CollapseID && strings.EqualFold(p.n.DeviceToken, f.n.DeviceToken) {
+			q.pending = append(q.pending[:i], q.pending[i+1:]...)
+			q.queued--
+			q.bytes -= p.size()
+			return p
+		}
+	}
+	return nil
+}
+
+// shed takes off the pending futures making room for f within max bytes,
+// if they can, and tells whether f fits. q must be locked.
+func (q *sendQueue) shed(f *Future, max int) (shed []*Future, fits bool) {
+	over := q.bytes + f.size() - max
+	if max <= 0 || over <= 0 {
+		return nil, true
+	}
+
+	var candidates []int
+	for i, p := range q.pending {
+		if p.priority() <= f.priority() {
+			candidates = append(candidates, i)
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return q.pending[candidates[i]].priority() < q.pending[candidates[j]].priority()
+	})
+	freed, n := 0, 0
+	for ; n < len(candidates) && freed < over; n++ {
+		freed += q.pending[candidates[n]].size()
+	}
+	if freed < over {
+		return nil, false
+	}
+
+	taken := make(map[int]bool)
+	for _, i := range candidates[:n] {
+		taken[i] = true
+		shed = append(shed, q.pending[i])
+		q.queued--
+		q.bytes -= q.pending[i].size()
+	}
+	kept := q.pending[:0]
+	for i, p := range q.pending {
+		if !taken[i] {
+			kept = append(kept, p)
+		}
+	}
+	q.pending = kept
+	return shed, true
+}
+
+func (client *ApnsConn) runQueue() {
+	q := &client.queue
+	for {
+		// one at a time, the others can be taken by SnapshotQueue
+		q.mu.Lock()
+		if len(q.pending) == 0 {
+			q.running = false
+			q.notify()
+			q.mu.Unlock()
+			return
+		}
+		f := q.pending[0]
+		q.pending = q.pending[1:]
+		q.mu.Unlock()
+
+		client.sendFuture(f)
+		q.mu.Lock()
+		q.queued--
+		q.bytes -= f.size()
+		q.mu.Unlock()
+	}
+}
+
+// SnapshotQueue takes the SendAsync notifications not sent yet off the
+// queue and writes them to w as JSON lines, e.g. to hand them to the
+// process replacing this one with RestoreQueue. Their futures fail with
+// ErrSnapshotted. The one being sent, if any, is not included. If w fails
+// the notifications are queued again.
+func (client *ApnsConn) SnapshotQueue(w io.Writer) error {
+	q := &client.queue
+	q.mu.Lock()
+	taken := q.pending
+	q.pending = nil
+	q.queued -= len(taken)
+	for _, f := range taken {
+		q.bytes -= f.size()
+	}
+	q.mu.Unlock()
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, f := range taken {
+		enc.Encode(f.n)
+	}
+	_, err := w.Write(buf.Bytes())
+	if err != nil {
+		q.mu.Lock()
+		q.pending = append(taken, q.pending...)
+		q.queued += len(taken)
+		for _, f := range taken {
+			q.bytes += f.size()
+		}
+		if !q.running && len(q.pending) > 0 {
+			q.running = true
+			go client.runQueue()
+		}
+		q.mu.Unlock()
+		return err
+	}
+
+	for _, f := range taken {
+		f.resolve(nil, ErrSnapshotted)
+	}
+	return nil
+}
+
+// RestoreQueue reads notifications written by SnapshotQueue from r and
+// queues them with SendAsync, in order. It returns their futures, those
+// read before an error included.
+func (client *ApnsConn) RestoreQueue(r io.Reader) ([]*Future, error) {
+	var futures []*Future
+	dec := json.NewDecoder(r)
+	for {
+		var n Notification
+		err := dec.Decode(&n)
+		if err == io.EOF {
+			return futures, nil
+		}
+		if err != nil {
+			return futures, err
+		}
+		futures = append(futures, client.SendAsync(n))
+	}
+}
+
+// sendFuture sends the notification of f and resolves f, at once or,
+// with a background reader, once the reader or the settle time decides.
+func (client *ApnsConn) sendFuture(f *Future) {
+	if !client.readsInBackground() {
+		f.resolve(client.sendNotification(context.Background(), &f.n))
+		return
+	}
+
+	// registered before the write, the answer can come at once
+	if f.n.Identifier == 0 {
+		f.n.Identifier = client.NextIdentifier()
+	}
+	id := f.n.Identifier
+	q := &client.queue
+	q.mu.Lock()
+	if q.settling == nil {
+		q.settling = make(map[uint32]*Future)
+	}
+	if q.settling[id] != nil {
+		q.mu.Unlock()
+		f.resolve(nil, ErrIdentifierInUse)
+		return
+	}
+	q.settling[id] = f
+	q.mu.Unlock()
+
+	resp, err := client.sendNotification(context.Background(), &f.n)
+	if err != nil {
+		q.resolveLate(id, resp, err)
+		return
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.settling[id] == f {
+		f.settle = time.AfterFunc(client.settleTime(), func() {
+			q.mu.Lock()
+			settled := q.settling[id] == f
+			if settled {
+				delete(q.settling, id)
+				q.notify()
+			}
+			q.mu.Unlock()
+			if settled {
+				f.resolve(resp, nil)
+			}
+		})
+	}
+}
+
+// take removes the future written as id from the settling ones, nil if
+// there is none.
+func (q *sendQueue) take(id uint32) *Future {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	f := q.settling[id]
+	delete(q.settling, id)
+	if f != nil {
+		if f.settle != nil {
+			f.settle.Stop()
+		}
+		q.notify()
+	}
+	return f
+}
+
+// notify wakes up drain. q must be locked.
+func (q *sendQueue) notify() {
+	if q.changed != nil {
+		close(q.changed)
+		q.changed = nil
+	}
+}
+
+// drain waits until the worker sent every queued notification and their
+// futures settled, or until ctx is done.
+func (q *sendQueue) drain(ctx context.Context) {
+	for {
+		q.mu.Lock()
+		if !q.running && len(q.settling) == 0 {
+			q.mu.Unlock()
+			return
+		}
+		if q.changed == nil {
+			q.changed = make(chan struct{})
+		}
+		changed := q.changed
+		q.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// resolveLate resolves the future written as id, if one is settling. The
+// client must not be locked: callbacks may send again.
+func (q *sendQueue) resolveLate(id uint32, resp *Response, err error) {
+	if f := q.take(id); f != nil {
+		f.resolve(resp, err)
+	}
+}
+
+// lateResult is the outcome of a settling future, decided while the
+// client is locked and resolved after.
+type lateResult struct {
+	f    *Future
+	resp *Response
+	err  error
+}
+
+func (l lateResult) resolve() {
+	if l.f != nil {
+		l.f.resolve(l.resp, l.err)
+	}
+}
+
+// extend restarts the settle time of the future written as id, when it
+// was resent.
+func (q *sendQueue) extend(id uint32, settle time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if f := q.settling[id]; f != nil && f.settle != nil {
+		f.settle.Reset(settle)
+	}
+}
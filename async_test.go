@@ -0,0 +1,353 @@
+package apns
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func Test_SendAsync(t *testing.T) {
+	server, transport := pipeConn(t)
+	client := &ApnsConn{ReadTimeout: 5 * time.Millisecond, Transport: transport}
+
+	ids := make(chan uint32, 10)
+	go func() {
+		for {
+			header := make([]byte, 5)
+			if _, err := io.ReadFull(server, header); err != nil {
+				return
+			}
+			frame := make([]byte, binary.BigEndian.Uint32(header[1:]))
+			if _, err := io.ReadFull(server, frame); err != nil {
+				return
+			}
+			// token and payload items of 2 bytes each, then the id item
+			ids <- binary.BigEndian.Uint32(frame[13:])
+		}
+	}()
+
+	var futures []*Future
+	for i := uint32(1); i <= 5; i++ {
+		futures = append(futures, client.SendAsync(Notification{DeviceToken: "0a0b", Payload: []byte("{}"), Identifier: i}))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for i, f := range futures {
+		resp, err := f.Wait(ctx)
+		if err != nil || !resp.Accepted() {
+			t.Fatalf("Notification %d failed: %v %v", i+1, resp, err)
+		}
+		if id := <-ids; id != uint32(i+1) {
+			t.Errorf("Notification %d sent out of order, got %d", i+1, id)
+		}
+	}
+
+	client.Close(context.Background())
+	resp, err := client.SendAsync(Notification{DeviceToken: "0a0b", Payload: []byte("{}")}).Wait(ctx)
+	if err != ErrClientClosed {
+		t.Errorf("Expected ErrClientClosed, got %v %v", resp, err)
+	}
+}
+
+func Test_SendCallback(t *testing.T) {
+	client := &ApnsConn{}
+	client.Close(context.Background())
+
+	results := make(chan error, 1)
+	client.SendCallback(Notification{DeviceToken: "0a0b", Identifier: 7}, func(n Notification, resp *Response, err error) {
+		if n.Identifier != 7 {
+			t.Errorf("Callback got notification %d", n.Identifier)
+		}
+		results <- err
+	})
+
+	select {
+	case err := <-results:
+		if err != ErrClientClosed {
+			t.Errorf("Expected ErrClientClosed, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Callback was not called")
+	}
+}
+
+func Test_SendAsyncQueueFull(t *testing.T) {
+	// nothing is read until release: the first send blocks the queue
+	server, transport := pipeConn(t)
+	client := &ApnsConn{MaxQueued: 2, ReadTimeout: NO_READ_WAIT, Transport: transport}
+
+	first := client.SendAsync(Notification{DeviceToken: "0a0b", Payload: []byte("{}")})
+	second := client.SendAsync(Notification{DeviceToken: "0a0b", Payload: []byte("{}")})
+	_, err := client.SendAsync(Notification{DeviceToken: "0a0b", Payload: []byte("{}")}).Wait(context.Background())
+	if err != ErrQueueFull {
+		t.Errorf("Expected ErrQueueFull, got %v", err)
+	}
+
+	go io.Copy(io.Discard, server)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, f := range []*Future{first, second} {
+		if _, err := f.Wait(ctx); err != nil {
+			t.Error(err)
+		}
+	}
+	if _, err := client.SendAsync(Notification{DeviceToken: "0a0b", Payload: []byte("{}")}).Wait(ctx); err != nil {
+		t.Errorf("Queue still full once drained: %v", err)
+	}
+}
+
+// waitPending waits until the SendAsync worker took all but n of the
+// queued notifications.
+func waitPending(client *ApnsConn, n int) {
+	for {
+		client.queue.mu.Lock()
+		pending := len(client.queue.pending)
+		client.queue.mu.Unlock()
+		if pending == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func Test_SendAsyncCollapse(t *testing.T) {
+	// nothing is read until release: the first send blocks the queue
+	server, transport := pipeConn(t)
+	client := &ApnsConn{ReadTimeout: NO_READ_WAIT, Transport: transport}
+	send := func(token, collapseID, payload string) *Future {
+		return client.SendAsync(Notification{DeviceToken: token, Payload: []byte(payload), CollapseID: collapseID})
+	}
+
+	// being sent, it cannot be replaced any more
+	first := send("0a0b", "score", `{"n":1}`)
+	waitPending(client, 0)
+	replaced := send("0a0b", "score", `{"n":2}`)
+	other := send("0c0d", "score", `{"n":2}`)
+	newest := send("0A0B", "score", `{"n":3}`)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := replaced.Wait(ctx); err != ErrCollapsed {
+		t.Errorf("Expected ErrCollapsed, got %v", err)
+	}
+	go io.Copy(io.Discard, server)
+	for _, f := range []*Future{first, other, newest} {
+		if _, err := f.Wait(ctx); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func Test_SendAsyncShed(t *testing.T) {
+	// nothing is read until release: the first send blocks the queue, 4
+	// bytes each
+	server, transport := pipeConn(t)
+	var shed []string
+	client := &ApnsConn{MaxQueuedBytes: 12, ReadTimeout: NO_READ_WAIT, Transport: transport}
+	client.OnShed = func(n Notification) { shed = append(shed, n.DeviceToken) }
+	send := func(token string, priority uint8) *Future {
+		return client.SendAsync(Notification{DeviceToken: token, Payload: []byte("{}"), Priority: priority})
+	}
+
+	first := send("0a01", 0)
+	waitPending(client, 0)
+	low := send("0a02", PRIORITY_CONSERVE_POWER)
+	kept := send("0a03", PRIORITY_IMMEDIATE)
+	// makes room by shedding the low priority one, then is the only one
+	// left for the next
+	last := send("0a04", 0)
+	lowest := send("0a05", PRIORITY_CONSERVE_POWER)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, f := range []*Future{low, lowest} {
+		if _, err := f.Wait(ctx); err != ErrShed {
+			t.Errorf("Expected ErrShed, got %v", err)
+		}
+	}
+	if len(shed) != 2 || shed[0] != "0a02" || shed[1] != "0a05" {
+		t.Errorf("Unexpected notifications shed %v", shed)
+	}
+
+	go io.Copy(io.Discard, server)
+	for _, f := range []*Future{first, kept, last} {
+		if _, err := f.Wait(ctx); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func Test_SendAsyncBackgroundReader(t *testing.T) {
+	// the second notification is rejected once the third was written
+	client := &ApnsConn{BackgroundReader: true, ReadTimeout: 100 * time.Millisecond, Transport: servedTransport(t, func(server net.Conn, _ int) {
+		b := make([]byte, 256)
+		var rejected []byte
+		for i := 1; i <= 3; i++ {
+			n, err := server.Read(b)
+			if err != nil {
+				return
+			}
+			if i == 2 {
+				rejected = append([]byte(nil), b[n-11:n-7]...)
+			}
+		}
+		server.Write(append([]byte{8, byte(StatusInvalidToken)}, rejected...))
+		server.Close()
+	})}
+
+	var futures []*Future
+	for _, token := range []string{"0a", "0b", "0c"} {
+		futures = append(futures, client.SendAsync(Notification{DeviceToken: token, Payload: []byte("{}")}))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if resp, err := futures[0].Wait(ctx); err != nil || !resp.Accepted() {
+		t.Errorf("Expected the first notification to settle as accepted, got %v %v", resp, err)
+	}
+	resp, err := futures[1].Wait(ctx)
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || resp == nil || resp.Status != StatusInvalidToken {
+		t.Errorf("Expected the rejection of the second notification, got %v %v", resp, err)
+	}
+	if _, err := futures[2].Wait(ctx); !errors.Is(err, ErrDroppedAfterRejection) {
+		t.Errorf("Expected the third notification to be dropped, got %v", err)
+	}
+}
+
+func Test_SendCallbackResendsDropped(t *testing.T) {
+	// the first connection rejects its first notification once the second
+	// was written, later ones take everything
+	client := &ApnsConn{BackgroundReader: true, ReadTimeout: 100 * time.Millisecond, Transport: servedTransport(t, func(server net.Conn, dial int) {
+		first := dial == 1
+		b := make([]byte, 256)
+		var rejected []byte
+		for i := 1; ; i++ {
+			n, err := server.Read(b)
+			if err != nil {
+				return
+			}
+			if first && i == 1 {
+				rejected = append([]byte(nil), b[n-11:n-7]...)
+			} else if first {
+				server.Write(append([]byte{8, byte(StatusInvalidToken)}, rejected...))
+				server.Close()
+				return
+			}
+		}
+	})}
+
+	resent := make(chan error, 1)
+	client.SendAsync(Notification{DeviceToken: "0a", Payload: []byte("{}")})
+	client.SendCallback(Notification{DeviceToken: "0b", Payload: []byte("{}")}, func(n Notification, resp *Response, err error) {
+		if !errors.Is(err, ErrDroppedAfterRejection) {
+			resent <- err
+			return
+		}
+		// sending again from the callback, as the error allows
+		_, err = client.Send(&Notification{DeviceToken: n.DeviceToken, Payload: n.Payload})
+		resent <- err
+	})
+
+	select {
+	case err := <-resent:
+		if err != nil {
+			t.Errorf("Resend from the callback failed: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Resend from the callback blocked")
+	}
+}
+
+func Test_SendAsyncIdentifierInUse(t *testing.T) {
+	transport, _ := pipeTransport(t)
+	client := &ApnsConn{BackgroundReader: true, ReadTimeout: 50 * time.Millisecond, Transport: transport}
+
+	first := client.SendAsync(Notification{DeviceToken: "0a", Payload: []byte("{}"), Identifier: 7})
+	second := client.SendAsync(Notification{DeviceToken: "0b", Payload: []byte("{}"), Identifier: 7})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if _, err := second.Wait(ctx); err != ErrIdentifierInUse {
+		t.Errorf("Expected ErrIdentifierInUse, got %v", err)
+	}
+	if resp, err := first.Wait(ctx); err != nil || !resp.Accepted() {
+		t.Errorf("Expected the first notification to settle, got %v %v", resp, err)
+	}
+}
+
+func Test_SnapshotQueue(t *testing.T) {
+	// nothing is read: the first notification blocks the worker
+	server, transport := pipeConn(t)
+	client := &ApnsConn{ReadTimeout: NO_READ_WAIT, Transport: transport}
+
+	var futures []*Future
+	for _, token := range []string{"0a", "0b", "0c"} {
+		futures = append(futures, client.SendAsync(Notification{DeviceToken: token, Payload: []byte("{}"), Priority: PRIORITY_CONSERVE_POWER}))
+	}
+	waitPending(client, 2)
+
+	var snapshot bytes.Buffer
+	if err := client.SnapshotQueue(&snapshot); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range futures[1:] {
+		if _, err := f.Wait(context.Background()); err != ErrSnapshotted {
+			t.Errorf("Expected ErrSnapshotted, got %v", err)
+		}
+	}
+	go io.Copy(io.Discard, server)
+	if _, err := futures[0].Wait(context.Background()); err != nil {
+		t.Errorf("Notification being sent failed: %v", err)
+	}
+
+	transport, received := pipeTransport(t)
+	next := &ApnsConn{ReadTimeout: NO_READ_WAIT, Transport: transport}
+	restored, err := next.RestoreQueue(&snapshot)
+	if err != nil || len(restored) != 2 {
+		t.Fatalf("Restored %d notifications: %v", len(restored), err)
+	}
+	for i, f := range restored {
+		if _, err := f.Wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		// token item, then the payload one
+		if pkt := <-received; !bytes.Contains(pkt, []byte{1, 0, 1, byte(0x0b + i)}) || pkt[len(pkt)-1] != PRIORITY_CONSERVE_POWER {
+			t.Errorf("Unexpected notification % x", pkt)
+		}
+	}
+}
+
+func Test_CloseFlushesSendAsync(t *testing.T) {
+	for _, background := range []bool{false, true} {
+		transport, _ := pipeTransport(t)
+		client := &ApnsConn{BackgroundReader: background, ReadTimeout: 20 * time.Millisecond, Transport: transport}
+
+		var futures []*Future
+		for i := uint32(1); i <= 5; i++ {
+			futures = append(futures, client.SendAsync(Notification{DeviceToken: "0a0b", Payload: []byte("{}"), Identifier: i}))
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := client.Close(ctx); err != nil {
+			t.Errorf("Close failed: %v", err)
+		}
+		for i, f := range futures {
+			select {
+			case <-f.Done():
+			default:
+				t.Fatalf("Notification %d still pending after Close (background %v)", i+1, background)
+			}
+			if resp, err := f.Wait(ctx); err != nil || !resp.Accepted() {
+				t.Errorf("Notification %d failed (background %v): %v %v", i+1, background, resp, err)
+			}
+		}
+		cancel()
+	}
+}
@@ -0,0 +1,117 @@
+package apns
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"strings"
+	"time"
+)
+
+// AuthErrorReason tells why Apple would not, or did not, accept the client
+// certificate.
+type AuthErrorReason int
+
+const (
+	CertificateExpired AuthErrorReason = iota
+	CertificateNotYetValid
+	CertificateRevoked
+	CertificateWrongEnvironment
+	CertificateRejected
+)
+
+var authRemediation = map[AuthErrorReason]string{
+	CertificateExpired:          "renew the push certificate in the Apple Developer portal and deploy the new cert/key pair",
+	CertificateNotYetValid:      "check the system clock, or wait until the certificate validity period starts",
+	CertificateRevoked:          "the certificate was revoked: create a new push certificate in the Apple Developer portal",
+	CertificateWrongEnvironment: "use the sandbox gateway with development certificates and the production gateway with production ones",
+	CertificateRejected:         "check that the certificate is an APNs certificate for this app and matches the private key",
+}
+
+var authText = map[AuthErrorReason]string{
+	CertificateExpired:          "Certificate expired",
+	CertificateNotYetValid:      "Certificate not yet valid",
+	CertificateRevoked:          "Certificate revoked",
+	CertificateWrongEnvironment: "Certificate issued for the other APNs environment",
+	CertificateRejected:         "Certificate rejected by the gateway",
+}
+
+// AuthError is returned when connecting fails because of the client
+// certificate. Remediation suggests how to fix it.
+type AuthError struct {
+	Reason      AuthErrorReason
+	Remediation string
+	Err         error // underlying handshake error, if any
+}
+
+func newAuthError(reason AuthErrorReason, err error) *AuthError {
+	return &AuthError{Reason: reason, Remediation: authRemediation[reason], Err: err}
+}
+
+func (e *AuthError) Error() string {
+	text := authText[e.Reason]
+	if e.Err != nil {
+		text += ": " + e.Err.Error()
+	}
+	return text + " (" + e.Remediation + ")"
+}
+
+func (e *AuthError) Unwrap() error {
+	return e.Err
+}
+
+func isSandboxEndpoint(endpoint string) bool {
+	return strings.Contains(endpoint, "sandbox")
+}
+
+// checkCertificate catches certificate problems locally, before Apple
+// answers them with a bare handshake failure.
+func checkCertificate(cert *tls.Certificate, endpoint string, now time.Time) error {
+	if cert == nil || len(cert.Certificate) == 0 {
+		return nil
+	}
+
+	leaf := cert.Leaf
+	if leaf == nil {
+		var err error
+		leaf, err = x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return err
+		}
+	}
+
+	if now.After(leaf.NotAfter) {
+		return newAuthError(CertificateExpired, nil)
+	}
+	if now.Before(leaf.NotBefore) {
+		return newAuthError(CertificateNotYetValid, nil)
+	}
+
+	// "Apple Development IOS Push Services: <bundle>" only works on the
+	// sandbox, "Apple Production IOS Push Services" only in production.
+	// Universal "Apple Push Services" certificates work on both.
+	name := leaf.Subject.CommonName
+	sandbox := isSandboxEndpoint(endpoint)
+	if (strings.HasPrefix(name, "Apple Development") && !sandbox) ||
+		(strings.HasPrefix(name, "Apple Production") && sandbox) {
+		return newAuthError(CertificateWrongEnvironment, nil)
+	}
+
+	return nil
+}
+
+// classifyHandshakeError turns the TLS alerts sent by the gateway for a bad
+// client certificate into an AuthError.
+func classifyHandshakeError(err error) error {
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "tls: expired certificate"):
+		return newAuthError(CertificateExpired, err)
+	case strings.Contains(msg, "tls: revoked certificate"):
+		return newAuthError(CertificateRevoked, err)
+	case strings.Contains(msg, "tls: bad certificate"),
+		strings.Contains(msg, "tls: unknown certificate authority"),
+		strings.Contains(msg, "tls: certificate required"):
+		return newAuthError(CertificateRejected, err)
+	}
+	return err
+}
@@ -0,0 +1,82 @@
+package apns
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func testCertificate(t *testing.T, name string, notBefore, notAfter time.Time) *tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// topicCertificate is a valid certificate for topic, in the subject UID
+// like Apple's.
+func topicCertificate(t *testing.T, topic string) *tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{
+			CommonName: "Apple Push Services: " + topic,
+			ExtraNames: []pkix.AttributeTypeAndValue{{Type: oidUserID, Value: topic}},
+		},
+		NotBefore: time.Now().Add(-time.Hour),
+		NotAfter:  time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func expectAuthError(t *testing.T, err error, reason AuthErrorReason) {
+	t.Helper()
+	var authErr *AuthError
+	if !errors.As(err, &authErr) || authErr.Reason != reason {
+		t.Errorf("Expected auth error %d, got %v", reason, err)
+	}
+}
+
+func Test_checkCertificate(t *testing.T) {
+	now := time.Now()
+	year := 365 * 24 * time.Hour
+
+	cert := testCertificate(t, "Apple Production IOS Push Services: com.example.app", now.Add(-year), now.Add(year))
+	if err := checkCertificate(cert, "gateway.push.apple.com:2195", now); err != nil {
+		t.Error(err)
+	}
+	expectAuthError(t, checkCertificate(cert, "gateway.sandbox.push.apple.com:2195", now), CertificateWrongEnvironment)
+	expectAuthError(t, checkCertificate(cert, "gateway.push.apple.com:2195", now.Add(2*year)), CertificateExpired)
+
+	cert = testCertificate(t, "Apple Push Services: com.example.app", now.Add(-year), now.Add(year))
+	if err := checkCertificate(cert, "gateway.sandbox.push.apple.com:2195", now); err != nil {
+		t.Error(err)
+	}
+
+	expectAuthError(t, classifyHandshakeError(errors.New("remote error: tls: revoked certificate")), CertificateRevoked)
+}
@@ -0,0 +1,32 @@
+package apns
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func BenchmarkCreateCommandTwoPacket(b *testing.B) {
+	token := bytes.Repeat([]byte{0xA}, 32)
+	payload := []byte(`{"aps":{"alert":"Hello","badge":1}}`)
+	buf := new(bytes.Buffer)
+	expiration := time.Now()
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		createCommandTwoPacket(buf, uint32(i), expiration, token, payload, PRIORITY_IMMEDIATE)
+	}
+}
+
+func BenchmarkPayloadMarshal(b *testing.B) {
+	p := NewPayload()
+	p.SetAlert(&Alert{Title: "Game Request", Body: "Bob wants to play poker"})
+	p.SetBadge(1)
+	p.SetCustom("game", 42)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		p.MarshalJSON()
+	}
+}
@@ -0,0 +1,90 @@
+package apns
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"time"
+)
+
+// Push types reported in BillingRecord.
+const (
+	PUSH_TYPE_ALERT      = "alert"
+	PUSH_TYPE_BACKGROUND = "background"
+	PUSH_TYPE_VOIP       = "voip"
+)
+
+// BillingRecord accounts for one notification written to the gateway.
+type BillingRecord struct {
+	Tenant   string
+	Topic    string // bundle id of the client certificate, if it has one
+	PushType string
+	Bytes    int // size of the packet on the wire
+	Time     time.Time
+}
+
+// BillingSink collects usage records, e.g. to charge push traffic back to
+// the teams sending it. Record is called while the client is locked and
+// must not block.
+type BillingSink interface {
+	Record(rec BillingRecord)
+}
+
+// BillingFunc adapts a function to the BillingSink interface.
+type BillingFunc func(rec BillingRecord)
+
+func (f BillingFunc) Record(rec BillingRecord) {
+	f(rec)
+}
+
+// oidUserID is the subject UID attribute, which holds the bundle id in
+// Apple push certificates.
+var oidUserID = asn1.ObjectIdentifier{0, 9, 2342, 19200300, 100, 1, 1}
+
+func subjectTopic(cert *x509.Certificate) string {
+	for _, name := range cert.Subject.Names {
+		if name.Type.Equal(oidUserID) {
+			if topic, ok := name.Value.(string); ok {
+				return topic
+			}
+		}
+	}
+	return ""
+}
+
+func (client *ApnsConn) certificateTopic() string {
+	client.topicOnce.Do(func() {
+		cert := client.certificate()
+		if cert == nil {
+			return
+		}
+		leaf := cert.Leaf
+		if leaf == nil && len(cert.Certificate) > 0 {
+			leaf, _ = x509.ParseCertificate(cert.Certificate[0])
+		}
+		if leaf != nil {
+			client.topic = subjectTopic(leaf)
+		}
+	})
+	return client.topic
+}
+
+func (client *ApnsConn) bill(payload []byte, size int) {
+	if client.BillingSink == nil {
+		return
+	}
+
+	pushType := PUSH_TYPE_ALERT
+	if client.VoIP {
+		pushType = PUSH_TYPE_VOIP
+	} else if isSilentPayload(payload) {
+		pushType = PUSH_TYPE_BACKGROUND
+	}
+
+	client.BillingSink.Record(BillingRecord{
+		Tenant:   client.Tenant,
+		Topic:    client.certificateTopic(),
+		PushType: pushType,
+		Bytes:    size,
+		Time:     client.now(),
+	})
+}
@@ -0,0 +1,46 @@
+package apns
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"testing"
+	"time"
+)
+
+func Test_BillingSink(t *testing.T) {
+	server, transport := pipeConn(t)
+	go io.Copy(io.Discard, server)
+
+	var records []BillingRecord
+	client := &ApnsConn{
+		ReadTimeout: 10 * time.Millisecond,
+		Tenant:      "news",
+		BillingSink: BillingFunc(func(rec BillingRecord) { records = append(records, rec) }),
+		Transport:   transport,
+	}
+
+	_, err := client.Send(&Notification{DeviceToken: "0a0b0c", Payload: []byte(`{"aps":{"content-available":1}}`), Priority: PRIORITY_CONSERVE_POWER})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("Expected 1 record, got %d", len(records))
+	}
+	rec := records[0]
+	// command, frame length, 3 byte token item, 31 byte payload item,
+	// id, expiration and priority items
+	if rec.Tenant != "news" || rec.PushType != PUSH_TYPE_BACKGROUND || rec.Bytes != 5+6+34+7+7+4 {
+		t.Errorf("Unexpected record %+v", rec)
+	}
+}
+
+func Test_subjectTopic(t *testing.T) {
+	cert := &x509.Certificate{Subject: pkix.Name{
+		CommonName: "Apple Push Services: com.example.app",
+		Names:      []pkix.AttributeTypeAndValue{{Type: oidUserID, Value: "com.example.app"}},
+	}}
+	if topic := subjectTopic(cert); topic != "com.example.app" {
+		t.Errorf("Unexpected topic %q", topic)
+	}
+}
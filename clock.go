@@ -0,0 +1,31 @@
+package apns
+
+import "time"
+
+// Clock tells the time, so that tests can fix it.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// SystemClock is the Clock used when none is set.
+var SystemClock Clock = systemClock{}
+
+// FixedClock always returns the same time.
+type FixedClock time.Time
+
+func (c FixedClock) Now() time.Time {
+	return time.Time(c)
+}
+
+func (client *ApnsConn) now() time.Time {
+	if client.Clock == nil {
+		return SystemClock.Now()
+	}
+	return client.Clock.Now()
+}
@@ -0,0 +1,64 @@
+// Command apns-bench measures the send throughput of the client against the
+// in-memory gateway of apnstest:
+//
+//	apns-bench -n 100000 -concurrency 4 -wait 0
+//
+// -wait is the read timeout of each send, 0 writes notifications without
+// waiting for error responses. -pipelined uses the pipelined mode, with
+// -wait as the time notifications are kept for resending.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/Mistobaan/go-apns"
+	"github.com/Mistobaan/go-apns/apnstest"
+)
+
+func main() {
+	count := flag.Int("n", 100000, "notifications to send")
+	concurrency := flag.Int("concurrency", 1, "connections to send over")
+	wait := flag.Duration("wait", 0, "read timeout of each send, 0 does not wait")
+	pipelined := flag.Bool("pipelined", false, "send in pipelined mode")
+	flag.Parse()
+
+	server := apnstest.NewServer()
+	server.Discard()
+
+	readTimeout := *wait
+	if readTimeout == 0 {
+		readTimeout = apns.NO_READ_WAIT
+	}
+	client := &apns.ApnsConn{Transport: server.Transport(), ReadTimeout: readTimeout, Pipelined: *pipelined}
+
+	tokens := make([]string, *count)
+	for i := range tokens {
+		tokens[i] = apnstest.Token
+	}
+	payload := []byte(`{"aps":{"alert":"Hello","badge":1}}`)
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	result := client.Fanout(context.Background(), payload, tokens, *concurrency)
+	runtime.ReadMemStats(&after)
+	client.Close(context.Background())
+
+	if len(result.Failed) > 0 {
+		for token, err := range result.Failed {
+			fmt.Fprintf(os.Stderr, "apns-bench: %s: %v\n", token, err)
+			break
+		}
+		os.Exit(1)
+	}
+
+	sent := float64(result.Accepted)
+	fmt.Printf("%d notifications in %v over %d connections\n", result.Accepted, result.Elapsed.Round(time.Millisecond), *concurrency)
+	fmt.Printf("%.0f notifications/s\n", sent/result.Elapsed.Seconds())
+	fmt.Printf("%.1f allocs/notification, %.0f B/notification\n",
+		float64(after.Mallocs-before.Mallocs)/sent, float64(after.TotalAlloc-before.TotalAlloc)/sent)
+}
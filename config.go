@@ -0,0 +1,126 @@
+package apns
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Gateway endpoints of the binary interface.
+const (
+	APPLE_GATEWAY         = "gateway.push.apple.com:2195"
+	APPLE_GATEWAY_SANDBOX = "gateway.sandbox.push.apple.com:2195"
+)
+
+// Config describes a client, e.g. loaded with FromEnv.
+type Config struct {
+	Environment string // ENV_PRODUCTION or ENV_SANDBOX, selects the default Gateway
+	Gateway     string // host:port, overrides Environment
+	CertFile    string
+	KeyFile     string
+	ReadTimeout time.Duration // 0 keeps the default
+	MaxIdle     time.Duration
+	MaxConnAge  time.Duration
+	VoIP        bool
+}
+
+// Endpoint is the gateway the client connects to.
+func (c *Config) Endpoint() string {
+	switch {
+	case c.Gateway != "":
+		return c.Gateway
+	case c.Environment == ENV_SANDBOX:
+		return APPLE_GATEWAY_SANDBOX
+	}
+	return APPLE_GATEWAY
+}
+
+// Validate reports every problem of the configuration at once.
+func (c *Config) Validate() error {
+	var errs []error
+	if c.Environment != "" && c.Environment != ENV_PRODUCTION && c.Environment != ENV_SANDBOX {
+		errs = append(errs, fmt.Errorf("Unknown environment %q, use %q or %q", c.Environment, ENV_PRODUCTION, ENV_SANDBOX))
+	}
+	if c.CertFile == "" {
+		errs = append(errs, errors.New("Missing certificate file"))
+	}
+	if c.KeyFile == "" {
+		errs = append(errs, errors.New("Missing key file"))
+	}
+	if c.ReadTimeout < 0 || c.MaxIdle < 0 || c.MaxConnAge < 0 {
+		errs = append(errs, errors.New("Timeouts must not be negative"))
+	}
+	return errors.Join(errs...)
+}
+
+// NewClient validates the configuration and creates its client.
+func (c *Config) NewClient(opts ...Option) (*ApnsConn, error) {
+	err := c.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	options := []Option{WithCertificateFiles(c.CertFile, c.KeyFile), WithConnectionLimits(c.MaxIdle, c.MaxConnAge)}
+	if c.ReadTimeout > 0 {
+		options = append(options, WithReadTimeout(c.ReadTimeout))
+	}
+	if c.VoIP {
+		options = append(options, WithVoIP())
+	}
+	return NewClientWithOptions(c.Endpoint(), append(options, opts...)...)
+}
+
+// FromEnv loads a validated Config from the environment:
+//
+//	APNS_ENVIRONMENT    production or sandbox
+//	APNS_GATEWAY        host:port, overrides APNS_ENVIRONMENT
+//	APNS_CERT_FILE      PEM certificate
+//	APNS_KEY_FILE       PEM private key
+//	APNS_READ_TIMEOUT   duration, e.g. 150ms
+//	APNS_MAX_IDLE       duration
+//	APNS_MAX_CONN_AGE   duration
+//	APNS_VOIP           true for a VoIP Services certificate
+func FromEnv() (*Config, error) {
+	c := &Config{
+		Environment: os.Getenv("APNS_ENVIRONMENT"),
+		Gateway:     os.Getenv("APNS_GATEWAY"),
+		CertFile:    os.Getenv("APNS_CERT_FILE"),
+		KeyFile:     os.Getenv("APNS_KEY_FILE"),
+	}
+
+	var errs []error
+	durations := []struct {
+		name string
+		d    *time.Duration
+	}{
+		{"APNS_READ_TIMEOUT", &c.ReadTimeout},
+		{"APNS_MAX_IDLE", &c.MaxIdle},
+		{"APNS_MAX_CONN_AGE", &c.MaxConnAge},
+	}
+	for _, v := range durations {
+		value := os.Getenv(v.name)
+		if value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Invalid %s: %v", v.name, err))
+		}
+		*v.d = d
+	}
+	if value := os.Getenv("APNS_VOIP"); value != "" {
+		voip, err := strconv.ParseBool(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Invalid APNS_VOIP: %v", err))
+		}
+		c.VoIP = voip
+	}
+
+	err := errors.Join(append(errs, c.Validate())...)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
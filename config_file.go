@@ -0,0 +1,116 @@
+package apns
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// AppConfig is the configuration of one app in a config file.
+type AppConfig struct {
+	Topic string // bundle id, read from the certificate when empty
+	Config
+}
+
+// LoadConfigFile reads the apps of a JSON config file such as:
+//
+//	{"apps": [
+//		{"topic": "com.example.app", "environment": "production",
+//		 "cert_file": "app.pem", "key_file": "app.key", "read_timeout": "150ms"},
+//		{"environment": "sandbox", "cert_file": "voip.pem", "key_file": "voip.key", "voip": true}
+//	]}
+//
+// Every app is validated, the error reports the problems of all of them.
+func LoadConfigFile(path string) ([]AppConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file struct {
+		Apps []struct {
+			Topic       string `json:"topic"`
+			Environment string `json:"environment"`
+			Gateway     string `json:"gateway"`
+			CertFile    string `json:"cert_file"`
+			KeyFile     string `json:"key_file"`
+			ReadTimeout string `json:"read_timeout"`
+			MaxIdle     string `json:"max_idle"`
+			MaxConnAge  string `json:"max_conn_age"`
+			VoIP        bool   `json:"voip"`
+		} `json:"apps"`
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&file)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid config file %s: %w", path, err)
+	}
+	if len(file.Apps) == 0 {
+		return nil, fmt.Errorf("No apps in config file %s", path)
+	}
+
+	var errs []error
+	apps := make([]AppConfig, len(file.Apps))
+	for i, a := range file.Apps {
+		app := AppConfig{Topic: a.Topic, Config: Config{
+			Environment: a.Environment,
+			Gateway:     a.Gateway,
+			CertFile:    a.CertFile,
+			KeyFile:     a.KeyFile,
+			VoIP:        a.VoIP,
+		}}
+
+		var appErrs []error
+		for _, v := range []struct {
+			name, value string
+			d           *time.Duration
+		}{
+			{"read_timeout", a.ReadTimeout, &app.ReadTimeout},
+			{"max_idle", a.MaxIdle, &app.MaxIdle},
+			{"max_conn_age", a.MaxConnAge, &app.MaxConnAge},
+		} {
+			if v.value == "" {
+				continue
+			}
+			*v.d, err = time.ParseDuration(v.value)
+			if err != nil {
+				appErrs = append(appErrs, fmt.Errorf("Invalid %s: %v", v.name, err))
+			}
+		}
+		err = errors.Join(append(appErrs, app.Validate())...)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("App %d %s: %w", i, a.Topic, err))
+		}
+		apps[i] = app
+	}
+
+	err = errors.Join(errs...)
+	if err != nil {
+		return nil, err
+	}
+	return apps, nil
+}
+
+// NewManagerFromConfig creates a manager with a client for each app.
+// Options apply to every client.
+func NewManagerFromConfig(apps []AppConfig, opts ...Option) (*ApnsManager, error) {
+	m := NewManager()
+	for _, app := range apps {
+		client, err := app.NewClient(opts...)
+		var previous *ApnsConn
+		if err == nil {
+			previous, err = m.Add(app.Topic, client)
+		}
+		if err == nil && previous != nil {
+			err = errors.New("Topic configured twice")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("App %s: %w", app.Topic, err)
+		}
+	}
+	return m, nil
+}
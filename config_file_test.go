@@ -0,0 +1,50 @@
+package apns
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_LoadConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeCertificate(t, dir, testCertificate(t, "Apple Push Services", time.Now().Add(-time.Hour), time.Now().Add(time.Hour)))
+
+	path := filepath.Join(dir, "apns.json")
+	config := `{"apps": [
+		{"topic": "com.example.app", "cert_file": "` + certFile + `", "key_file": "` + keyFile + `", "read_timeout": "1s"},
+		{"topic": "com.example.app.voip", "environment": "sandbox", "cert_file": "` + certFile + `", "key_file": "` + keyFile + `", "voip": true}
+	]}`
+	err := os.WriteFile(path, []byte(config), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	apps, err := LoadConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewManagerFromConfig(apps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if topics := m.Topics(); len(topics) != 2 || topics[0] != "com.example.app" {
+		t.Fatalf("Unexpected topics %v", topics)
+	}
+	client, _ := m.Client("com.example.app")
+	voip, _ := m.Client("com.example.app.voip")
+	if client.ReadTimeout != time.Second || client.endpoint != APPLE_GATEWAY || !voip.VoIP || voip.endpoint != APPLE_GATEWAY_SANDBOX {
+		t.Error("Clients do not follow their config")
+	}
+
+	err = os.WriteFile(path, []byte(`{"apps": [{"topic": "a", "key_file": "k"}, {"topic": "b", "cert_file": "c", "key_file": "k", "max_idle": "x"}]}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = LoadConfigFile(path)
+	if err == nil || !strings.Contains(err.Error(), "certificate file") || !strings.Contains(err.Error(), "max_idle") {
+		t.Errorf("Expected the problems of both apps, got %v", err)
+	}
+}
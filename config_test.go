@@ -0,0 +1,36 @@
+package apns
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_FromEnv(t *testing.T) {
+	t.Setenv("APNS_ENVIRONMENT", "sandbox")
+	t.Setenv("APNS_CERT_FILE", "cert.pem")
+	t.Setenv("APNS_KEY_FILE", "key.pem")
+	t.Setenv("APNS_READ_TIMEOUT", "1s")
+	t.Setenv("APNS_VOIP", "true")
+
+	c, err := FromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Endpoint() != APPLE_GATEWAY_SANDBOX || c.ReadTimeout != time.Second || !c.VoIP {
+		t.Errorf("Unexpected config %+v", c)
+	}
+
+	t.Setenv("APNS_ENVIRONMENT", "staging")
+	t.Setenv("APNS_KEY_FILE", "")
+	t.Setenv("APNS_MAX_IDLE", "forever")
+	_, err = FromEnv()
+	if err == nil {
+		t.Fatal("Invalid config accepted")
+	}
+	for _, problem := range []string{"staging", "key file", "APNS_MAX_IDLE"} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("Error does not report %s: %v", problem, err)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package apns
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+)
+
+// REDACTED replaces the values of redacted keys in debug output.
+const REDACTED = "<redacted>"
+
+// PrettyPayload renders payload as indented JSON for humans, e.g. to paste
+// into Apple's push console. The values of the keys listed in redact are
+// replaced by REDACTED wherever they appear. Invalid JSON is returned as is
+// along with the parse error.
+func PrettyPayload(payload []byte, redact ...string) ([]byte, error) {
+	var doc interface{}
+	err := json.Unmarshal(payload, &doc)
+	if err != nil {
+		return payload, err
+	}
+
+	if len(redact) > 0 {
+		keys := make(map[string]bool, len(redact))
+		for _, key := range redact {
+			keys[key] = true
+		}
+		doc = redactKeys(doc, keys)
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	err = enc.Encode(doc)
+	if err != nil {
+		return payload, err
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
+
+func redactKeys(v interface{}, keys map[string]bool) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if keys[key] {
+				v[key] = REDACTED
+			} else {
+				v[key] = redactKeys(value, keys)
+			}
+		}
+	case []interface{}:
+		for i, value := range v {
+			v[i] = redactKeys(value, keys)
+		}
+	}
+	return v
+}
+
+func (client *ApnsConn) debugPayload(token string, payload []byte) {
+	if client.PayloadHook == nil {
+		return
+	}
+	pretty, _ := PrettyPayload(payload, client.RedactKeys...)
+	client.PayloadHook(token, pretty)
+}
+
+// encodeBuffered encodes the packet through a buffer, to dump or keep it,
+// before it is written.
+func (client *ApnsConn) encodeBuffered(encode func(w packetWriter, transactionId uint32) error, id uint32, payload []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	err := encode(&buf, id)
+	if err != nil {
+		return nil, err
+	}
+	if client.DumpPDUs {
+		client.dumpPDU("sent", buf.Bytes(), payload)
+	}
+	_, err = client.w.Write(buf.Bytes())
+	return buf.Bytes(), err
+}
+
+// dumpPDU logs pdu as a hex dump, with the payload masked when
+// RedactDumps is set: lengths and layout stay visible.
+func (client *ApnsConn) dumpPDU(direction string, pdu, payload []byte) {
+	if client.RedactDumps && len(payload) > 0 {
+		if i := bytes.Index(pdu, payload); i >= 0 {
+			pdu = append([]byte(nil), pdu...)
+			for j := i; j < i+len(payload); j++ {
+				pdu[j] = '*'
+			}
+		}
+	}
+	log.Printf("PDU %s (%d bytes):\n%s", direction, len(pdu), hex.Dump(pdu))
+}
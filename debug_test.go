@@ -0,0 +1,57 @@
+package apns
+
+import (
+	"bytes"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_PrettyPayload(t *testing.T) {
+	pretty, err := PrettyPayload([]byte(`{"aps":{"alert":"Hi <b>"},"email":"a@b.c"}`), "email")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{
+  "aps": {
+    "alert": "Hi <b>"
+  },
+  "email": "<redacted>"
+}`
+	if string(pretty) != expected {
+		t.Errorf("Unexpected output:\n%s", pretty)
+	}
+
+	_, err = PrettyPayload([]byte(`{"aps":`))
+	if err == nil {
+		t.Error("Invalid JSON accepted")
+	}
+}
+
+func Test_DumpPDUs(t *testing.T) {
+	var out bytes.Buffer
+	log.SetOutput(&out)
+	defer log.SetOutput(os.Stderr)
+
+	client := &ApnsConn{DumpPDUs: true, RedactDumps: true, ReadTimeout: time.Second, Transport: servedTransport(t, func(server net.Conn, _ int) {
+		server.Read(make([]byte, 256))
+		server.Write([]byte{8, byte(StatusInvalidToken), 0, 0, 0, 7})
+		server.Close()
+	})}
+
+	client.Send(&Notification{DeviceToken: "0a0b0c", Payload: []byte(`{"secret":1}`), Identifier: 7})
+
+	dump := out.String()
+	if !strings.Contains(dump, "PDU sent (") || !strings.Contains(dump, "PDU received (6 bytes)") {
+		t.Fatalf("Missing dumps:\n%s", dump)
+	}
+	if strings.Contains(dump, "secret") || !strings.Contains(dump, "0c 2a 2a") {
+		t.Errorf("Payload not redacted:\n%s", dump)
+	}
+	if !strings.Contains(dump, "08 08 00 00 00 07") {
+		t.Errorf("Error response not dumped:\n%s", dump)
+	}
+}
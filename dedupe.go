@@ -0,0 +1,87 @@
+package apns
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// DEDUPE_WINDOW is the default of RetryPolicy.DedupeWindow.
+const DEDUPE_WINDOW = time.Minute
+
+// acceptedIds remembers the identifiers of the notifications Apple
+// accepted during a window, oldest first, so that retries do not deliver
+// them twice.
+type acceptedIds struct {
+	mu    sync.Mutex
+	at    map[uint32]time.Time
+	order []acceptedId
+}
+
+type acceptedId struct {
+	id uint32
+	at time.Time
+}
+
+// expire forgets the identifiers accepted before since. a must be locked.
+func (a *acceptedIds) expire(since time.Time) {
+	i := 0
+	for ; i < len(a.order) && a.order[i].at.Before(since); i++ {
+		if at, found := a.at[a.order[i].id]; found && !at.After(a.order[i].at) {
+			delete(a.at, a.order[i].id)
+		}
+	}
+	a.order = a.order[i:]
+}
+
+func (a *acceptedIds) add(id uint32, now time.Time, window time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.expire(now.Add(-window))
+	if a.at == nil {
+		a.at = make(map[uint32]time.Time)
+	}
+	a.at[id] = now
+	a.order = append(a.order, acceptedId{id, now})
+}
+
+func (a *acceptedIds) contains(id uint32, now time.Time, window time.Duration) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.expire(now.Add(-window))
+	_, found := a.at[id]
+	return found
+}
+
+func (a *acceptedIds) remove(id uint32) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.at, id)
+}
+
+func (client *ApnsConn) dedupeWindow() time.Duration {
+	if client.Retry.DedupeWindow > 0 {
+		return client.Retry.DedupeWindow
+	}
+	return DEDUPE_WINDOW
+}
+
+// rememberAccepted records the notification of a successful send, or the
+// one a gateway shutdown names as the last accepted, when retrying.
+func (client *ApnsConn) rememberAccepted(resp *Response, err error) {
+	if client.Retry == nil || resp == nil {
+		return
+	}
+	var statusErr *StatusError
+	if err == nil {
+		client.accepted.add(resp.Identifier, client.now(), client.dedupeWindow())
+	} else if errors.As(err, &statusErr) && statusErr.Status == StatusShutdown && resp.FailedIdentifier != 0 {
+		client.accepted.add(resp.FailedIdentifier, client.now(), client.dedupeWindow())
+	}
+}
+
+// wasAccepted tells whether a retry of the notification id would deliver
+// it twice.
+func (client *ApnsConn) wasAccepted(id uint32) bool {
+	return client.Retry != nil && client.accepted.contains(id, client.now(), client.dedupeWindow())
+}
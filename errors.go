@@ -0,0 +1,104 @@
+package apns
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// PushError reports a failure observed on a client: a notification the
+// gateway rejected, or a connection error.
+type PushError struct {
+	Identifier uint32 // notification identifier, 0 for connection errors
+	Token      string // hex device token, if known
+	Status     Status // StatusNoErrors for connection errors
+	Err        error
+	Time       time.Time
+}
+
+func (e *PushError) Error() string {
+	if e.Identifier == 0 {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("Notification %d: %v", e.Identifier, e.Err)
+}
+
+func (e *PushError) Unwrap() error {
+	return e.Err
+}
+
+// ERRORS_BUFFER_SIZE is the capacity of the Errors channel.
+const ERRORS_BUFFER_SIZE = 256
+
+// Errors returns a channel receiving every PushError of the client, as an
+// alternative to checking the result of each send. Errors are dropped
+// while the channel is full, so keep reading it once it was requested.
+func (client *ApnsConn) Errors() <-chan *PushError {
+	if client.parent != nil {
+		return client.parent.Errors()
+	}
+	client.errorsMu.Lock()
+	defer client.errorsMu.Unlock()
+	if client.errors == nil {
+		client.errors = make(chan *PushError, ERRORS_BUFFER_SIZE)
+	}
+	return client.errors
+}
+
+// reportError publishes e on the Errors channel, if it was requested.
+func (client *ApnsConn) reportError(e *PushError) {
+	if client.parent != nil {
+		client.parent.reportError(e)
+		return
+	}
+	client.errorsMu.Lock()
+	defer client.errorsMu.Unlock()
+	if client.errors == nil {
+		return
+	}
+	select {
+	case client.errors <- e:
+	default:
+	}
+}
+
+// reportSendError reports a failed send: a rejection or a connection
+// error, but not a cancelled context.
+func (client *ApnsConn) reportSendError(resp *Response, token []byte, err error) {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return
+	}
+	e := &PushError{Token: hex.EncodeToString(token), Err: err, Time: client.now()}
+	if resp != nil {
+		e.Identifier = resp.Identifier
+		e.Status = resp.Status
+	}
+	client.reportError(e)
+}
+
+// lateRejection reports the rejection of a notification read after it was
+// sent, as a failure of that notification.
+func (client *ApnsConn) lateRejection(status Status, id uint32) error {
+	atomic.AddUint64(&client.counters().rejected[status], 1)
+	if status != StatusShutdown {
+		client.accepted.remove(id)
+	}
+
+	var err error = &StatusError{Status: status, Identifier: id}
+	if !status.IsKnown() {
+		err = &UnknownStatusError{Status: status, Identifier: id}
+	}
+
+	e := &PushError{Identifier: id, Status: status, Err: err, Time: client.now()}
+	if sent, found := client.history.lookup(id); found {
+		e.Token = hex.EncodeToString(sent.token)
+		if status.IsTokenInvalid() {
+			client.tokenInvalid(e.Token, e.Time)
+		}
+	}
+	client.reportError(e)
+	return err
+}
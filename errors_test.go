@@ -0,0 +1,41 @@
+package apns
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func Test_Errors(t *testing.T) {
+	server, conn := net.Pipe()
+	defer server.Close()
+	dials := 0
+	client := &ApnsConn{ReadTimeout: time.Second, Transport: TransportFunc(func(endpoint string, config *tls.Config) (net.Conn, error) {
+		dials++
+		if dials > 1 {
+			return nil, errors.New("connection refused")
+		}
+		return conn, nil
+	})}
+
+	go func() {
+		b := make([]byte, 256)
+		server.Read(b)
+		server.Write([]byte{8, byte(StatusInvalidToken), 0, 0, 0, 7})
+	}()
+
+	errs := client.Errors()
+	client.Send(&Notification{DeviceToken: "0a0b", Payload: []byte("{}"), Identifier: 7})
+	client.Send(&Notification{DeviceToken: "0a0b", Payload: []byte("{}")})
+
+	rejected := <-errs
+	if rejected.Identifier != 7 || rejected.Status != StatusInvalidToken || rejected.Token != "0a0b" {
+		t.Errorf("Unexpected rejection %+v", rejected)
+	}
+	refused := <-errs
+	if refused.Identifier != 0 || refused.Err == nil {
+		t.Errorf("Unexpected connection error %+v", refused)
+	}
+}
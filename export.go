@@ -0,0 +1,137 @@
+package apns
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"time"
+)
+
+// FeedbackExporter writes invalid tokens to a stream, one record per
+// feedback message, for batch token cleanup jobs.
+type FeedbackExporter interface {
+	Export(msg *ApnsFeedbackMessage) error
+	Flush() error
+}
+
+// Environment names used in exported records.
+const (
+	ENV_PRODUCTION = "production"
+	ENV_SANDBOX    = "sandbox"
+)
+
+func feedbackTime(msg *ApnsFeedbackMessage) string {
+	return time.Unix(int64(msg.Time_t), 0).UTC().Format(time.RFC3339)
+}
+
+// CSVExporter writes "environment,token,timestamp,unix" rows, preceded by
+// a header row.
+type CSVExporter struct {
+	Environment string
+	w           *csv.Writer
+	header      bool
+}
+
+func NewCSVExporter(w io.Writer, environment string) *CSVExporter {
+	return &CSVExporter{Environment: environment, w: csv.NewWriter(w)}
+}
+
+func (e *CSVExporter) Export(msg *ApnsFeedbackMessage) error {
+	if !e.header {
+		err := e.w.Write([]string{"environment", "token", "timestamp", "unix"})
+		if err != nil {
+			return err
+		}
+		e.header = true
+	}
+	return e.w.Write([]string{e.Environment, msg.DeviceToken, feedbackTime(msg), strconv.Itoa(int(msg.Time_t))})
+}
+
+func (e *CSVExporter) Flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// JSONExporter writes one JSON object per line:
+//
+//	{"environment":"production","token":"0a0b0c","timestamp":"2012-09-30T10:13:20Z","unix":1349000000}
+type JSONExporter struct {
+	Environment string
+	enc         *json.Encoder
+}
+
+type exportRecord struct {
+	Environment string `json:"environment"`
+	Token       string `json:"token"`
+	Timestamp   string `json:"timestamp"`
+	Unix        int32  `json:"unix"`
+}
+
+func NewJSONExporter(w io.Writer, environment string) *JSONExporter {
+	return &JSONExporter{Environment: environment, enc: json.NewEncoder(w)}
+}
+
+func (e *JSONExporter) Export(msg *ApnsFeedbackMessage) error {
+	return e.enc.Encode(exportRecord{
+		Environment: e.Environment,
+		Token:       msg.DeviceToken,
+		Timestamp:   feedbackTime(msg),
+		Unix:        msg.Time_t,
+	})
+}
+
+func (e *JSONExporter) Flush() error {
+	return nil
+}
+
+// GzipExporter gzips the output of another exporter. Close must be called
+// once done to write the gzip trailer; it does not close the underlying
+// writer.
+type GzipExporter struct {
+	FeedbackExporter
+	gz *gzip.Writer
+}
+
+// NewGzipExporter compresses into w what the exporter built by newExporter
+// writes, e.g.
+//
+//	e := NewGzipExporter(file, func(w io.Writer) FeedbackExporter {
+//		return NewCSVExporter(w, ENV_PRODUCTION)
+//	})
+func NewGzipExporter(w io.Writer, newExporter func(w io.Writer) FeedbackExporter) *GzipExporter {
+	gz := gzip.NewWriter(w)
+	return &GzipExporter{FeedbackExporter: newExporter(gz), gz: gz}
+}
+
+func (e *GzipExporter) Flush() error {
+	err := e.FeedbackExporter.Flush()
+	if err != nil {
+		return err
+	}
+	return e.gz.Flush()
+}
+
+func (e *GzipExporter) Close() error {
+	err := e.FeedbackExporter.Flush()
+	if err != nil {
+		return err
+	}
+	return e.gz.Close()
+}
+
+// ExportFeedback drains messages into e until the channel is closed, then
+// flushes e, or closes it if it is an io.Closer such as GzipExporter.
+func ExportFeedback(e FeedbackExporter, messages <-chan *ApnsFeedbackMessage) error {
+	for msg := range messages {
+		err := e.Export(msg)
+		if err != nil {
+			return err
+		}
+	}
+	if closer, ok := e.(io.Closer); ok {
+		return closer.Close()
+	}
+	return e.Flush()
+}
@@ -0,0 +1,60 @@
+package apns
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"testing"
+)
+
+func Test_Exporters(t *testing.T) {
+	messages := make(chan *ApnsFeedbackMessage, 2)
+	messages <- &ApnsFeedbackMessage{Time_t: 1349000000, DeviceToken: "0a0b0c"}
+	close(messages)
+
+	var buf bytes.Buffer
+	err := ExportFeedback(NewCSVExporter(&buf, ENV_SANDBOX), messages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "environment,token,timestamp,unix\nsandbox,0a0b0c,2012-09-30T10:13:20Z,1349000000\n"
+	if buf.String() != expected {
+		t.Errorf("Unexpected CSV output %q", buf.String())
+	}
+
+	buf.Reset()
+	e := NewJSONExporter(&buf, ENV_PRODUCTION)
+	e.Export(&ApnsFeedbackMessage{Time_t: 1349000000, DeviceToken: "0a0b0c"})
+	expected = `{"environment":"production","token":"0a0b0c","timestamp":"2012-09-30T10:13:20Z","unix":1349000000}` + "\n"
+	if buf.String() != expected {
+		t.Errorf("Unexpected JSON output %q", buf.String())
+	}
+}
+
+func Test_GzipExporter(t *testing.T) {
+	messages := make(chan *ApnsFeedbackMessage, 1)
+	messages <- &ApnsFeedbackMessage{Time_t: 1349000000, DeviceToken: "0a0b0c"}
+	close(messages)
+
+	var buf bytes.Buffer
+	e := NewGzipExporter(&buf, func(w io.Writer) FeedbackExporter {
+		return NewCSVExporter(w, ENV_SANDBOX)
+	})
+	err := ExportFeedback(e, messages)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gz, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csv, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "environment,token,timestamp,unix\nsandbox,0a0b0c,2012-09-30T10:13:20Z,1349000000\n"
+	if string(csv) != expected {
+		t.Errorf("Unexpected CSV output %q", csv)
+	}
+}
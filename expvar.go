@@ -0,0 +1,43 @@
+package apns
+
+import (
+	"errors"
+	"expvar"
+)
+
+// PublishExpvar publishes the client Stats under expvar, so that
+// /debug/vars shows them: prefix.sent, prefix.accepted, prefix.failed,
+// prefix.rejected (by status), prefix.retried, prefix.reconnects,
+// prefix.bytes_written and prefix.queue_depth. Each client needs its own
+// prefix, e.g. "apns" or "apns.com.example.app".
+func (client *ApnsConn) PublishExpvar(prefix string) error {
+	vars := map[string]func(s Stats) interface{}{
+		"sent":          func(s Stats) interface{} { return s.Sent },
+		"accepted":      func(s Stats) interface{} { return s.Accepted },
+		"failed":        func(s Stats) interface{} { return s.Failed },
+		"retried":       func(s Stats) interface{} { return s.Retried },
+		"reconnects":    func(s Stats) interface{} { return s.Reconnects },
+		"bytes_written": func(s Stats) interface{} { return s.BytesWritten },
+		"queue_depth":   func(s Stats) interface{} { return s.QueueDepth },
+		"rejected": func(s Stats) interface{} {
+			rejected := make(map[string]uint64, len(s.Rejected))
+			for status, count := range s.Rejected {
+				rejected[status.String()] = count
+			}
+			return rejected
+		},
+	}
+
+	for name := range vars {
+		if expvar.Get(prefix+"."+name) != nil {
+			return errors.New("Expvar already published: " + prefix + "." + name)
+		}
+	}
+	for name, value := range vars {
+		value := value
+		expvar.Publish(prefix+"."+name, expvar.Func(func() interface{} {
+			return value(client.Stats())
+		}))
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package apns
+
+import (
+	"expvar"
+	"testing"
+)
+
+func Test_PublishExpvar(t *testing.T) {
+	client := &ApnsConn{}
+	client.stats.sent = 3
+	client.stats.rejected[StatusInvalidToken] = 1
+
+	err := client.PublishExpvar("apns_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := expvar.Get("apns_test.sent").String(); v != "3" {
+		t.Errorf("Unexpected apns_test.sent %s", v)
+	}
+	if v := expvar.Get("apns_test.rejected").String(); v != `{"`+StatusInvalidToken.String()+`":1}` {
+		t.Errorf("Unexpected apns_test.rejected %s", v)
+	}
+
+	if (&ApnsConn{}).PublishExpvar("apns_test") == nil {
+		t.Error("Prefix published twice")
+	}
+}
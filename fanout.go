@@ -0,0 +1,139 @@
+package apns
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// FanoutResult sums up a Fanout.
+type FanoutResult struct {
+	Accepted int
+	Failed   map[string]error // by device token
+	Elapsed  time.Duration
+}
+
+// FailedTokens lists the tokens that were not accepted, sorted.
+func (r *FanoutResult) FailedTokens() []string {
+	tokens := make([]string, 0, len(r.Failed))
+	for token := range r.Failed {
+		tokens = append(tokens, token)
+	}
+	sort.Strings(tokens)
+	return tokens
+}
+
+// clone returns a client with the same configuration and its own
+// connection, which reports on the Errors channel of client and takes its
+// identifiers. New options must be added here, Test_CloneCopiesOptions
+// checks that none is missing.
+func (client *ApnsConn) clone() *ApnsConn {
+	// SetCertificate and ReloadCertificate replace the configuration with
+	// the client locked
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	return &ApnsConn{
+		tls_cfg:  client.tls_cfg,
+		endpoint: client.endpoint,
+		parent:   client,
+
+		Transport:      client.Transport,
+		TokenStore:     client.TokenStore,
+		ReadTimeout:    client.ReadTimeout,
+		VoIP:           client.VoIP,
+		MaxIdle:        client.MaxIdle,
+		MaxConnAge:     client.MaxConnAge,
+		PayloadHook:    client.PayloadHook,
+		RedactKeys:     client.RedactKeys,
+		DumpPDUs:       client.DumpPDUs,
+		RedactDumps:    client.RedactDumps,
+		StrictPayload:  client.StrictPayload,
+		OnTokenInvalid: client.OnTokenInvalid,
+		RegisteredAt:   client.RegisteredAt,
+		BillingSink:    client.BillingSink,
+		Tenant:         client.Tenant,
+		Retry:          client.Retry,
+		Clock:          client.Clock,
+		OnConnect:      client.OnConnect,
+		OnReconnect:    client.OnReconnect,
+		OnDisconnect:   client.OnDisconnect,
+		OnSend:         client.OnSend,
+		BeforeSend:     client.BeforeSend,
+
+		UnsafeAllowUnwrap: client.UnsafeAllowUnwrap,
+		MaxQueued:         client.MaxQueued,
+		MaxQueuedBytes:    client.MaxQueuedBytes,
+		OnShed:            client.OnShed,
+		BackgroundReader:  client.BackgroundReader,
+		Pipelined:         client.Pipelined,
+		PipelineWindow:    client.PipelineWindow,
+		FeedbackReconnect: client.FeedbackReconnect,
+	}
+}
+
+// Fanout sends the same payload to every hex token, over concurrency
+// connections: the client's own and concurrency-1 extra ones, closed when
+// done. Tokens left when ctx is done fail with ctx.Err().
+func (client *ApnsConn) Fanout(ctx context.Context, payload []byte, tokens []string, concurrency int) *FanoutResult {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	if concurrency > len(tokens) {
+		concurrency = len(tokens)
+	}
+
+	start := time.Now()
+	result := &FanoutResult{Failed: make(map[string]error)}
+	var mu sync.Mutex
+
+	queue := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		worker := client
+		if i > 0 {
+			worker = client.clone()
+			defer worker.Close(context.Background())
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for token := range queue {
+				resp, err := worker.SendContext(ctx, &Notification{DeviceToken: token, Payload: payload})
+				if err == nil && !resp.Accepted() {
+					err = &StatusError{Status: resp.Status, Identifier: resp.FailedIdentifier}
+				}
+
+				mu.Lock()
+				if err != nil {
+					result.Failed[token] = err
+				} else {
+					result.Accepted++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for i, token := range tokens {
+		select {
+		case queue <- token:
+			continue
+		case <-ctx.Done():
+		}
+
+		mu.Lock()
+		for _, token := range tokens[i:] {
+			result.Failed[token] = ctx.Err()
+		}
+		mu.Unlock()
+		break
+	}
+	close(queue)
+	wg.Wait()
+
+	result.Elapsed = time.Since(start)
+	return result
+}
@@ -0,0 +1,266 @@
+package apns
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"log"
+	"net"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_Fanout(t *testing.T) {
+	var mu sync.Mutex
+	dials := 0
+	client := &ApnsConn{ReadTimeout: 10 * time.Millisecond, Transport: servedTransport(t, func(server net.Conn, _ int) {
+		mu.Lock()
+		dials++
+		mu.Unlock()
+
+		// reject the 0x0bad token, accept everything else
+		for {
+			header := make([]byte, 5)
+			if _, err := io.ReadFull(server, header); err != nil {
+				return
+			}
+			frame := make([]byte, binary.BigEndian.Uint32(header[1:]))
+			if _, err := io.ReadFull(server, frame); err != nil {
+				return
+			}
+			if frame[3] == 0x0b && frame[4] == 0xad {
+				server.Write([]byte{8, byte(StatusInvalidToken), 0, 0, 0, 1})
+				server.Close()
+				return
+			}
+		}
+	})}
+
+	tokens := []string{"0a01", "0a02", "0bad", "0a03", "0a04", "0a05"}
+	result := client.Fanout(context.Background(), []byte("{}"), tokens, 3)
+	if result.Accepted != 5 {
+		t.Errorf("Expected 5 accepted notifications, got %d", result.Accepted)
+	}
+	if failed := result.FailedTokens(); len(failed) != 1 || failed[0] != "0bad" {
+		t.Errorf("Unexpected failed tokens %v", failed)
+	}
+	if dials < 3 {
+		t.Errorf("Expected at least 3 connections, got %d", dials)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	result = client.Fanout(ctx, []byte("{}"), tokens, 2)
+	if result.Accepted != 0 || len(result.Failed) != len(tokens) {
+		t.Errorf("Cancelled fanout sent notifications: %+v", result)
+	}
+}
+
+func Test_CloneConfiguration(t *testing.T) {
+	client := &ApnsConn{StrictPayload: true, ReadTimeout: time.Second, Retry: &RetryPolicy{MaxAttempts: 3}}
+	client.endpoint = "gateway.example:2195"
+
+	clone := client.clone()
+	if !clone.StrictPayload || clone.ReadTimeout != time.Second || clone.Retry != client.Retry || clone.endpoint != client.endpoint {
+		t.Errorf("Configuration not copied: %+v", clone)
+	}
+
+	// a payload the lint rejects fails on the clone too, before dialing
+	payload := []byte(`{"aps":{"content_available":1}}`)
+	clone.Transport = TransportFunc(func(endpoint string, config *tls.Config) (net.Conn, error) {
+		t.Error("Clone dialed for a payload rejected by StrictPayload")
+		return nil, io.EOF
+	})
+	_, err := clone.Send(&Notification{DeviceToken: "0a0b", Payload: payload})
+	if err == nil || err.Error() != LintPayload(payload).Error() {
+		t.Error("Expected the lint error, got", err)
+	}
+}
+
+func Test_CloneCopiesOptions(t *testing.T) {
+	// every exported field is an option: set them all, then compare
+	client := &ApnsConn{}
+	v := reflect.ValueOf(client).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		f := v.Field(i)
+		switch f.Kind() {
+		case reflect.Bool:
+			f.SetBool(true)
+		case reflect.Int, reflect.Int64:
+			f.SetInt(1)
+		case reflect.String:
+			f.SetString(field.Name)
+		case reflect.Slice:
+			f.Set(reflect.MakeSlice(f.Type(), 1, 1))
+		case reflect.Pointer:
+			f.Set(reflect.New(f.Type().Elem()))
+		case reflect.Func:
+			f.Set(reflect.MakeFunc(f.Type(), func([]reflect.Value) []reflect.Value { return nil }))
+		}
+	}
+	client.Transport = &TLSTransport{}
+	client.TokenStore = NewMemoryTokenStore()
+	client.BillingSink = BillingFunc(func(BillingRecord) {})
+	client.Clock = FixedClock{}
+
+	clone := client.clone()
+	c := reflect.ValueOf(clone).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		src, dst := v.Field(i), c.Field(i)
+		if src.IsZero() {
+			t.Fatalf("%s not set by the test", field.Name)
+		}
+		if dst.IsZero() {
+			t.Errorf("%s not copied", field.Name)
+			continue
+		}
+		if src.Kind() == reflect.Interface {
+			src, dst = src.Elem(), dst.Elem()
+		}
+		switch src.Kind() {
+		case reflect.Func, reflect.Slice, reflect.Pointer:
+			if src.Pointer() != dst.Pointer() {
+				t.Errorf("%s not copied", field.Name)
+			}
+		default:
+			if !reflect.DeepEqual(src.Interface(), dst.Interface()) {
+				t.Errorf("%s not copied", field.Name)
+			}
+		}
+	}
+	if clone.parent != client {
+		t.Error("Clone does not report to the client")
+	}
+}
+
+func Test_FanoutHooks(t *testing.T) {
+	var mu sync.Mutex
+	connects, disconnects := 0, 0
+	transport, _ := pipeTransport(t)
+	client := &ApnsConn{ReadTimeout: 10 * time.Millisecond, Transport: transport}
+	client.OnConnect = func(attempt int) {
+		mu.Lock()
+		connects++
+		mu.Unlock()
+	}
+	client.OnDisconnect = func(reason error) {
+		mu.Lock()
+		disconnects++
+		mu.Unlock()
+	}
+
+	result := client.Fanout(context.Background(), []byte("{}"), []string{"0a01", "0a02", "0a03", "0a04"}, 3)
+	if result.Accepted != 4 {
+		t.Fatalf("Expected 4 accepted notifications, got %d", result.Accepted)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	// workers may share the tokens unevenly; the clones that connected
+	// are closed when done, the client stays connected
+	if connects < 2 || disconnects != connects-1 {
+		t.Errorf("Expected the hooks of the clones, got %d connects and %d disconnects", connects, disconnects)
+	}
+}
+
+func Test_FanoutClock(t *testing.T) {
+	now := time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC)
+	var mu sync.Mutex
+	var times []time.Time
+	transport, _ := pipeTransport(t)
+	client := &ApnsConn{ReadTimeout: 10 * time.Millisecond, Clock: FixedClock(now), Transport: transport}
+	client.BillingSink = BillingFunc(func(rec BillingRecord) {
+		mu.Lock()
+		times = append(times, rec.Time)
+		mu.Unlock()
+	})
+
+	client.Fanout(context.Background(), []byte("{}"), []string{"0a01", "0a02", "0a03", "0a04"}, 3)
+	mu.Lock()
+	defer mu.Unlock()
+	if len(times) != 4 {
+		t.Fatalf("Expected 4 billing records, got %d", len(times))
+	}
+	for _, at := range times {
+		if !at.Equal(now) {
+			t.Errorf("Record at %v, expected the client's clock %v", at, now)
+		}
+	}
+}
+
+func Test_FanoutDumps(t *testing.T) {
+	var out bytes.Buffer
+	log.SetOutput(&out)
+	defer log.SetOutput(os.Stderr)
+
+	transport, _ := pipeTransport(t)
+	client := &ApnsConn{DumpPDUs: true, RedactDumps: true, ReadTimeout: NO_READ_WAIT, Transport: transport}
+
+	tokens := []string{"0a01", "0a02", "0a03", "0a04"}
+	client.Fanout(context.Background(), []byte(`{"secret":1}`), tokens, 3)
+	if dumps := strings.Count(out.String(), "PDU sent"); dumps != len(tokens) {
+		t.Errorf("Expected %d dumps, got %d", len(tokens), dumps)
+	}
+	if strings.Contains(out.String(), "secret") {
+		t.Error("Payload not redacted by a clone:\n" + out.String())
+	}
+}
+
+func Test_FanoutErrors(t *testing.T) {
+	// every connection rejects the 0x0bad token
+	client := &ApnsConn{ReadTimeout: 50 * time.Millisecond, Transport: servedTransport(t, func(server net.Conn, _ int) {
+		b := make([]byte, 256)
+		for {
+			n, err := server.Read(b)
+			if err != nil {
+				return
+			}
+			if bytes.Contains(b[:n], []byte{0x0b, 0xad}) {
+				server.Write(append([]byte{8, byte(StatusInvalidToken)}, b[n-11:n-7]...))
+				server.Close()
+				return
+			}
+		}
+	})}
+	errs := client.Errors()
+
+	tokens := []string{"0a01", "0bad", "0a02", "0bad", "0a03", "0bad"}
+	client.Fanout(context.Background(), []byte("{}"), tokens, 3)
+
+	ids := make(map[uint32]bool)
+	for i := 0; i < 3; i++ {
+		select {
+		case e := <-errs:
+			if e.Token != "0bad" || e.Status != StatusInvalidToken || ids[e.Identifier] {
+				t.Errorf("Unexpected error %+v", e)
+			}
+			ids[e.Identifier] = true
+		case <-time.After(time.Second):
+			t.Fatalf("Only %d rejections reported on the client's Errors", i)
+		}
+	}
+}
+
+func Test_FanoutStats(t *testing.T) {
+	transport, _ := pipeTransport(t)
+	client := &ApnsConn{ReadTimeout: 10 * time.Millisecond, Transport: transport}
+
+	tokens := []string{"0a01", "0a02", "0a03", "0a04", "0a05"}
+	client.Fanout(context.Background(), []byte("{}"), tokens, 3)
+	if stats := client.Stats(); stats.Sent != 5 || stats.Accepted != 5 {
+		t.Errorf("Expected the clones to count for the client, got %+v", stats)
+	}
+}
@@ -1,34 +1,33 @@
 package apns
 
 import (
-	"encoding/hex"
+	"bufio"
+	"bytes"
+	"context"
 	"encoding/binary"
+	"encoding/hex"
 	"errors"
-	"bytes"
-	"time"
-	"log"
+	"fmt"
 	"io"
-	"bufio"
+	"log"
+	"time"
 )
 
-
 const APPLE_FEEDBACK string = "feedback.push.apple.com:2196"
 const APPLE_FEEDBACK_SANDBOX string = "feedback.sandbox.push.apple.com:2196"
 
 // NewFeedbackClient create a client for apple's Feedback system
-//  
 func NewFeedbackClient(endpoint, certificate, key string) (*ApnsConn, error) {
 	return NewClient(endpoint, certificate, key)
 }
 
-
 type ApnsFeedbackMessage struct {
 	Time_t      int32
 	DeviceToken string
 }
 
 func parseAppleFeedbackMessage(readb []byte) (*ApnsFeedbackMessage, error) {
-	var size int16
+	var size uint16
 	var err error
 	msg := &ApnsFeedbackMessage{}
 
@@ -48,6 +47,10 @@ func parseAppleFeedbackMessage(readb []byte) (*ApnsFeedbackMessage, error) {
 		return nil, err
 	}
 
+	if int(size) > MAX_DEVICE_TOKEN_SIZE {
+		return nil, fmt.Errorf("Invalid device token size %d", size)
+	}
+
 	if (6 + int(size)) > len(readb) {
 		return nil, errors.New("The Message size for the DeviceToken is bigger than the given buffer")
 	}
@@ -57,67 +60,187 @@ func parseAppleFeedbackMessage(readb []byte) (*ApnsFeedbackMessage, error) {
 	return msg, nil
 }
 
-// StartListening listens on a apple Feedback connection and produces an ApnsFeedbackMessage 
+// readFeedbackMessage reads one tuple from r: the 6 byte header, then the
+// token whatever its length, however the stream was split into reads.
+func readFeedbackMessage(r io.Reader) (*ApnsFeedbackMessage, error) {
+	header := [6]byte{}
+	_, err := io.ReadFull(r, header[:])
+	if err != nil {
+		return nil, err
+	}
+
+	size := binary.BigEndian.Uint16(header[4:])
+	readb := make([]byte, 6+int(size))
+	copy(readb, header[:])
+	_, err = io.ReadFull(r, readb[6:])
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return parseAppleFeedbackMessage(readb)
+}
+
+// ReconnectPolicy sets how the feedback listener reconnects after losing
+// its connection.
+type ReconnectPolicy struct {
+	// MaxAttempts in a row before giving up and closing the channel, 0 to
+	// reconnect forever.
+	MaxAttempts int
+
+	// Backoff returns the delay before an attempt, starting at 1.
+	Backoff func(attempt int) time.Duration
+
+	// OnReconnect, if set, is called after every attempt with its error,
+	// nil once reconnected.
+	OnReconnect func(attempt int, err error)
+}
+
+// DefaultReconnectPolicy tries 3 times, 30 seconds apart.
+var DefaultReconnectPolicy = &ReconnectPolicy{
+	MaxAttempts: 3,
+	Backoff: func(attempt int) time.Duration {
+		return 30 * time.Second
+	},
+}
+
+// reconnectFeedback replaces the lost feedback connection as set by
+// client.FeedbackReconnect, returning a reader on the new one.
+func (client *ApnsConn) reconnectFeedback(ctx context.Context, cause error) (*bufio.Reader, error) {
+	policy := client.FeedbackReconnect
+	if policy == nil {
+		policy = DefaultReconnectPolicy
+	}
+	backoff := policy.Backoff
+	if backoff == nil {
+		backoff = defaultFeedbackBackoff
+	}
+
+	client.mu.Lock()
+	client.disconnect(cause)
+	client.mu.Unlock()
+
+	err := cause
+	for attempt := 1; policy.MaxAttempts == 0 || attempt <= policy.MaxAttempts; attempt++ {
+		delay := backoff(attempt)
+		log.Printf("Feedback: connection lost (%v), try reconnection in %v", err, delay)
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		var r *bufio.Reader
+		client.mu.Lock()
+		err = client.connect()
+		if err == nil {
+			client.conn.SetReadDeadline(time.Time{}) //Do not timeout
+			r = bufio.NewReader(client.conn)
+		}
+		client.mu.Unlock()
+
+		if policy.OnReconnect != nil {
+			policy.OnReconnect(attempt, err)
+		}
+		if err == nil {
+			log.Printf("Feedback: reconnected")
+			return r, nil
+		}
+	}
+	return nil, err
+}
+
+var defaultFeedbackBackoff = ExponentialBackoff(time.Second, time.Minute)
+
+// StartListening listens on a apple Feedback connection and produces an ApnsFeedbackMessage
 // each time a valid message is found
-// If EOF is received the goroutine will try to re-connect 3 times waiting 5, 10 and 15 seconds
-func (client *ApnsConn) StartListening() <-chan *ApnsFeedbackMessage {
+// When the connection is lost it reconnects as set by FeedbackReconnect, by default 3 times
+// waiting 30 seconds each time, then closes the channel.
+// Cancelling ctx or calling Stop closes the connection and the channel.
+func (client *ApnsConn) StartListening(ctx context.Context) <-chan *ApnsFeedbackMessage {
 	outChan := make(chan *ApnsFeedbackMessage)
 
+	client.mu.Lock()
 	err := client.connect()
+	client.mu.Unlock()
 	if err != nil {
 		close(outChan)
 		log.Printf("Could not Connect to feedback Service %v", err.Error())
 		return outChan
 	}
 
-	go func() {
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	client.feedbackMu.Lock()
+	client.stopFeedback = func() {
+		cancel()
+		<-done
+	}
+	client.feedbackMu.Unlock()
 
-		readb := [4 + 2 + 32]byte{} // SSL default datapacket size
+	go func() {
+		// unblock the pending read
+		<-ctx.Done()
+		client.mu.Lock()
+		client.disconnect(ctx.Err())
+		client.mu.Unlock()
+	}()
 
-		client.tlsconn.SetReadDeadline(time.Time{}) //Do not timeout
+	go func() {
+		defer close(done)
+		defer close(outChan)
+		defer cancel()
 
-		buff_reader := bufio.NewReader(client.tlsconn)
+		client.mu.Lock()
+		client.conn.SetReadDeadline(time.Time{}) //Do not timeout
+		buff_reader := bufio.NewReader(client.conn)
+		client.mu.Unlock()
 
 		for {
-			n, err := buff_reader.Read(readb[:])
-			if err == io.EOF {
-				for count := 0; count < 3; count += 1 {
-					err = client.shutdown()
-					if err != nil {
-						log.Printf("Error closing the connection: %v", err)
-					}
-
-					log.Printf("Feedback: try reconnection in 30 sec")
-
-					time.Sleep(time.Second * 30)
-					err = client.connect()
-					if err != nil {
-						log.Print(err)
-					} else {
-						log.Printf("Feedback: reconnected")
-						client.tlsconn.SetReadDeadline(time.Time{}) //Do not timeout
-						buff_reader = bufio.NewReader(client.tlsconn)
-						break
-					}
-					if count == 3 {
-						panic("Failed reconnecting more than 3 times to the feedback service")
-					}
-				}
-			} else if err != nil {
-				close(outChan)
-				panic(err)
-			} else {
-				// parse all the messages
-				msg, err := parseAppleFeedbackMessage(readb[:n])
+			msg, err := readFeedbackMessage(buff_reader)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				buff_reader, err = client.reconnectFeedback(ctx, err)
 				if err != nil {
-					close(outChan)
-					panic(err)
-				} else {
-					outChan <- msg
+					if ctx.Err() == nil {
+						log.Printf("Feedback: giving up reconnecting: %v", err)
+					}
+					return
 				}
+				continue
+			}
+
+			at := time.Unix(int64(msg.Time_t), 0)
+			if client.RegisteredAt.since(msg.DeviceToken, at) {
+				// the app was reinstalled, the token is valid again
+				continue
+			}
+			client.tokenInvalid(msg.DeviceToken, at)
+			select {
+			case outChan <- msg:
+			case <-ctx.Done():
+				return
 			}
 		}
 	}()
 
 	return outChan
 }
+
+// Stop stops the feedback listener started by StartListening and waits
+// until its channel is closed.
+func (client *ApnsConn) Stop() {
+	client.feedbackMu.Lock()
+	stop := client.stopFeedback
+	client.stopFeedback = nil
+	client.feedbackMu.Unlock()
+
+	if stop != nil {
+		stop()
+	}
+}
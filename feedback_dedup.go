@@ -0,0 +1,80 @@
+package apns
+
+import (
+	"sort"
+	"time"
+)
+
+// RegisteredAtFunc returns when the app last registered token with the
+// provider, false if unknown.
+type RegisteredAtFunc func(token string) (time.Time, bool)
+
+// since tells whether token was registered again after at.
+func (f RegisteredAtFunc) since(token string, at time.Time) bool {
+	if f == nil {
+		return false
+	}
+	registered, found := f(token)
+	return found && registered.After(at)
+}
+
+// DedupFeedback reads messages and, every window, emits every token seen
+// in it once with its newest timestamp, oldest first. What is left is
+// emitted when messages is closed; with a window of 0 only then, e.g. for
+// a feedback poll bounded by the context given to StartListening. If
+// registeredAt is set, tokens registered again after Apple reported them
+// are dropped: the app was reinstalled and the token is valid again.
+func DedupFeedback(messages <-chan *ApnsFeedbackMessage, window time.Duration, registeredAt RegisteredAtFunc) <-chan *ApnsFeedbackMessage {
+	out := make(chan *ApnsFeedbackMessage)
+
+	go func() {
+		defer close(out)
+
+		var tick <-chan time.Time
+		if window > 0 {
+			ticker := time.NewTicker(window)
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+
+		newest := make(map[string]*ApnsFeedbackMessage)
+		for {
+			select {
+			case msg, ok := <-messages:
+				if !ok {
+					emitFeedback(out, newest, registeredAt)
+					return
+				}
+				if seen, found := newest[msg.DeviceToken]; !found || msg.Time_t > seen.Time_t {
+					newest[msg.DeviceToken] = msg
+				}
+			case <-tick:
+				emitFeedback(out, newest, registeredAt)
+				newest = make(map[string]*ApnsFeedbackMessage)
+			}
+		}
+	}()
+
+	return out
+}
+
+// emitFeedback writes the messages of newest to out, oldest first.
+func emitFeedback(out chan<- *ApnsFeedbackMessage, newest map[string]*ApnsFeedbackMessage, registeredAt RegisteredAtFunc) {
+	unique := make([]*ApnsFeedbackMessage, 0, len(newest))
+	for _, msg := range newest {
+		if registeredAt.since(msg.DeviceToken, time.Unix(int64(msg.Time_t), 0)) {
+			continue
+		}
+		unique = append(unique, msg)
+	}
+	sort.Slice(unique, func(i, j int) bool {
+		if unique[i].Time_t != unique[j].Time_t {
+			return unique[i].Time_t < unique[j].Time_t
+		}
+		return unique[i].DeviceToken < unique[j].DeviceToken
+	})
+
+	for _, msg := range unique {
+		out <- msg
+	}
+}
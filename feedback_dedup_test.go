@@ -0,0 +1,64 @@
+package apns
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_DedupFeedback(t *testing.T) {
+	messages := make(chan *ApnsFeedbackMessage, 5)
+	messages <- &ApnsFeedbackMessage{Time_t: 300, DeviceToken: "0a"}
+	messages <- &ApnsFeedbackMessage{Time_t: 100, DeviceToken: "0b"}
+	messages <- &ApnsFeedbackMessage{Time_t: 500, DeviceToken: "0a"}
+	messages <- &ApnsFeedbackMessage{Time_t: 200, DeviceToken: "0c"}
+	messages <- &ApnsFeedbackMessage{Time_t: 400, DeviceToken: "0a"}
+	close(messages)
+
+	// 0c registered again after Apple reported it
+	registeredAt := func(token string) (time.Time, bool) {
+		if token == "0c" {
+			return time.Unix(250, 0), true
+		}
+		return time.Time{}, false
+	}
+
+	var got []ApnsFeedbackMessage
+	for msg := range DedupFeedback(messages, 0, registeredAt) {
+		got = append(got, *msg)
+	}
+
+	expected := []ApnsFeedbackMessage{{Time_t: 100, DeviceToken: "0b"}, {Time_t: 500, DeviceToken: "0a"}}
+	if len(got) != len(expected) || got[0] != expected[0] || got[1] != expected[1] {
+		t.Errorf("Unexpected messages %v", got)
+	}
+}
+
+func Test_DedupFeedbackWindow(t *testing.T) {
+	messages := make(chan *ApnsFeedbackMessage)
+	out := DedupFeedback(messages, 20*time.Millisecond, nil)
+
+	// the input stays open: the first window is emitted anyway
+	messages <- &ApnsFeedbackMessage{Time_t: 300, DeviceToken: "0a"}
+	messages <- &ApnsFeedbackMessage{Time_t: 100, DeviceToken: "0b"}
+	messages <- &ApnsFeedbackMessage{Time_t: 400, DeviceToken: "0a"}
+	for _, expected := range []ApnsFeedbackMessage{{Time_t: 100, DeviceToken: "0b"}, {Time_t: 400, DeviceToken: "0a"}} {
+		select {
+		case msg := <-out:
+			if *msg != expected {
+				t.Errorf("Unexpected message %v, expected %v", *msg, expected)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Window not emitted while the input is open")
+		}
+	}
+
+	messages <- &ApnsFeedbackMessage{Time_t: 500, DeviceToken: "0a"}
+	close(messages)
+	var got []ApnsFeedbackMessage
+	for msg := range out {
+		got = append(got, *msg)
+	}
+	if len(got) != 1 || got[0].Time_t != 500 {
+		t.Errorf("Unexpected messages in the last window %v", got)
+	}
+}
@@ -0,0 +1,98 @@
+package apns
+
+import (
+	"os"
+	"time"
+)
+
+// FeedbackSink persists batches of feedback messages.
+type FeedbackSink interface {
+	Store(msgs []ApnsFeedbackMessage) error
+}
+
+// FeedbackSinkFunc adapts a function to the FeedbackSink interface.
+type FeedbackSinkFunc func(msgs []ApnsFeedbackMessage) error
+
+func (f FeedbackSinkFunc) Store(msgs []ApnsFeedbackMessage) error {
+	return f(msgs)
+}
+
+// ChannelSink sends every stored message to a channel.
+type ChannelSink chan<- ApnsFeedbackMessage
+
+func (c ChannelSink) Store(msgs []ApnsFeedbackMessage) error {
+	for _, msg := range msgs {
+		c <- msg
+	}
+	return nil
+}
+
+// ExporterSink writes stored messages with a FeedbackExporter, flushing it
+// after every batch.
+type ExporterSink struct {
+	Exporter FeedbackExporter
+}
+
+func (s *ExporterSink) Store(msgs []ApnsFeedbackMessage) error {
+	for i := range msgs {
+		err := s.Exporter.Export(&msgs[i])
+		if err != nil {
+			return err
+		}
+	}
+	return s.Exporter.Flush()
+}
+
+// FileSink appends stored messages to a file as JSON lines, see
+// JSONExporter.
+type FileSink struct {
+	ExporterSink
+	file *os.File
+}
+
+// NewFileSink opens, or creates, the file at path for appending.
+func NewFileSink(path, environment string) (*FileSink, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSink{ExporterSink{NewJSONExporter(file, environment)}, file}, nil
+}
+
+func (s *FileSink) Close() error {
+	return s.file.Close()
+}
+
+// StoreFeedback drains messages into sink in batches of up to batchSize,
+// storing a partial batch once it is interval old, and the rest when the
+// channel is closed. It stops at the first error.
+func StoreFeedback(sink FeedbackSink, messages <-chan *ApnsFeedbackMessage, batchSize int, interval time.Duration) error {
+	var batch []ApnsFeedbackMessage
+	var timeout <-chan time.Time
+
+	for {
+		select {
+		case msg, open := <-messages:
+			if !open {
+				if len(batch) == 0 {
+					return nil
+				}
+				return sink.Store(batch)
+			}
+			if len(batch) == 0 {
+				timeout = time.After(interval)
+			}
+			batch = append(batch, *msg)
+			if len(batch) < batchSize {
+				continue
+			}
+		case <-timeout:
+		}
+
+		err := sink.Store(batch)
+		if err != nil {
+			return err
+		}
+		batch, timeout = nil, nil
+	}
+}
@@ -0,0 +1,67 @@
+package apns
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_StoreFeedback(t *testing.T) {
+	messages := make(chan *ApnsFeedbackMessage, 5)
+	for _, token := range []string{"0a", "0b", "0c", "0d", "0e"} {
+		messages <- &ApnsFeedbackMessage{Time_t: 1349000000, DeviceToken: token}
+	}
+	close(messages)
+
+	var sizes []int
+	err := StoreFeedback(FeedbackSinkFunc(func(msgs []ApnsFeedbackMessage) error {
+		sizes = append(sizes, len(msgs))
+		return nil
+	}), messages, 2, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sizes) != 3 || sizes[0] != 2 || sizes[1] != 2 || sizes[2] != 1 {
+		t.Errorf("Unexpected batches %v", sizes)
+	}
+}
+
+func Test_StoreFeedbackInterval(t *testing.T) {
+	messages := make(chan *ApnsFeedbackMessage)
+	stored := make(chan ApnsFeedbackMessage, 1)
+	go StoreFeedback(ChannelSink(stored), messages, 100, time.Millisecond)
+
+	messages <- &ApnsFeedbackMessage{DeviceToken: "0a"}
+	select {
+	case msg := <-stored:
+		if msg.DeviceToken != "0a" {
+			t.Errorf("Unexpected message %v", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Partial batch was not stored")
+	}
+	close(messages)
+}
+
+func Test_FileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "feedback.json")
+	sink, err := NewFileSink(path, ENV_SANDBOX)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sink.Store([]ApnsFeedbackMessage{{Time_t: 1349000000, DeviceToken: "0a0b0c"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.Close()
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"token":"0a0b0c"`) {
+		t.Errorf("Unexpected file content %s", b)
+	}
+}
@@ -1,10 +1,17 @@
 package apns
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
 	"testing"
+	"testing/iotest"
+	"time"
 )
 
-
 func Test_parseAppleFeedbackMessage(t *testing.T) {
 
 	msg, err := parseAppleFeedbackMessage([]byte{})
@@ -22,6 +29,11 @@ func Test_parseAppleFeedbackMessage(t *testing.T) {
 		t.Error("Invalid message passed: Message Size is bigger than buffer")
 	}
 
+	msg, err = parseAppleFeedbackMessage(append([]byte{0x0, 0x0, 0x0, 0x0, 0x80, 0x06}, make([]byte, 0x8006)...))
+	if err == nil {
+		t.Error("Invalid message passed: token size above MAX_DEVICE_TOKEN_SIZE")
+	}
+
 	msg, err = parseAppleFeedbackMessage([]byte{0x0, 0x0, 0x0, 0x0, 0x0, 0x3, 0xA, 0xB, 0xC})
 	if err != nil {
 		t.Error(err)
@@ -32,3 +44,128 @@ func Test_parseAppleFeedbackMessage(t *testing.T) {
 	}
 
 }
+
+func Test_readFeedbackMessage(t *testing.T) {
+	tuple := func(time byte, token ...byte) []byte {
+		return append([]byte{0, 0, 0, time, 0, byte(len(token))}, token...)
+	}
+	stream := append(tuple(1, 0xA, 0xB), tuple(2, 0xC, 0xD, 0xE)...)
+
+	// one byte per read, as the worst split of TLS records
+	r := iotest.OneByteReader(bytes.NewReader(append(stream, 0, 0, 0)))
+	for _, expected := range []string{"0a0b", "0c0d0e"} {
+		msg, err := readFeedbackMessage(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.DeviceToken != expected {
+			t.Errorf("Expected token %s, got %s", expected, msg.DeviceToken)
+		}
+	}
+
+	_, err := readFeedbackMessage(r)
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("Expected ErrUnexpectedEOF for a truncated tuple, got %v", err)
+	}
+}
+
+func Test_StartListeningStop(t *testing.T) {
+	server, transport := pipeConn(t)
+	client := &ApnsConn{Transport: transport}
+
+	go server.Write([]byte{0, 0, 0, 1, 0, 2, 0xA, 0xB})
+
+	messages := client.StartListening(context.Background())
+	msg := <-messages
+	if msg == nil || msg.DeviceToken != "0a0b" {
+		t.Fatalf("Unexpected message %v", msg)
+	}
+
+	client.Stop()
+	if _, open := <-messages; open {
+		t.Error("Channel still open after Stop")
+	}
+}
+
+func Test_FeedbackReconnect(t *testing.T) {
+	// connections 1 and 3 send one tuple and drop, the others are refused
+	dials := 0
+	client := &ApnsConn{Transport: TransportFunc(func(endpoint string, config *tls.Config) (net.Conn, error) {
+		dials++
+		if dials != 1 && dials != 3 {
+			return nil, errors.New("connection refused")
+		}
+		server, conn := net.Pipe()
+		go func(n byte) {
+			server.Write([]byte{0, 0, 0, n, 0, 1, n})
+			server.Close()
+		}(byte(dials))
+		return conn, nil
+	})}
+
+	var attempts []error
+	client.FeedbackReconnect = &ReconnectPolicy{
+		MaxAttempts: 2,
+		Backoff:     func(int) time.Duration { return time.Millisecond },
+		OnReconnect: func(attempt int, err error) { attempts = append(attempts, err) },
+	}
+
+	var tokens []string
+	for msg := range client.StartListening(context.Background()) {
+		tokens = append(tokens, msg.DeviceToken)
+	}
+
+	if len(tokens) != 2 || tokens[0] != "01" || tokens[1] != "03" {
+		t.Errorf("Unexpected tokens %v", tokens)
+	}
+	// refused then reconnected, then refused until giving up
+	if len(attempts) != 4 || attempts[0] == nil || attempts[1] != nil || attempts[2] == nil || attempts[3] == nil {
+		t.Errorf("Unexpected reconnection attempts %v", attempts)
+	}
+}
+
+func Test_FeedbackRegisteredAgain(t *testing.T) {
+	dials := 0
+	client := &ApnsConn{Transport: TransportFunc(func(endpoint string, config *tls.Config) (net.Conn, error) {
+		if dials++; dials > 1 {
+			return nil, errors.New("connection refused")
+		}
+		server, conn := net.Pipe()
+		go func() {
+			server.Write([]byte{0, 0, 0, 100, 0, 1, 0xA, 0, 0, 0, 100, 0, 1, 0xB})
+			server.Close()
+		}()
+		return conn, nil
+	})}
+	client.FeedbackReconnect = &ReconnectPolicy{MaxAttempts: 1, Backoff: func(int) time.Duration { return time.Millisecond }}
+	// 0b was registered again after Apple reported it
+	client.RegisteredAt = func(token string) (time.Time, bool) {
+		return time.Unix(200, 0), token == "0b"
+	}
+	var invalid []string
+	client.OnTokenInvalid = func(token string, at time.Time) { invalid = append(invalid, token) }
+
+	var tokens []string
+	for msg := range client.StartListening(context.Background()) {
+		tokens = append(tokens, msg.DeviceToken)
+	}
+	if len(tokens) != 1 || tokens[0] != "0a" || len(invalid) != 1 || invalid[0] != "0a" {
+		t.Errorf("Unexpected tokens %v, invalidated %v", tokens, invalid)
+	}
+}
+
+func FuzzReadFeedbackMessage(f *testing.F) {
+	f.Add([]byte{0, 0, 0, 1, 0, 2, 0xA, 0xB})
+	f.Add([]byte{0, 0, 0, 1, 0xFF, 0xFF})
+	f.Add(append([]byte{0, 0, 0, 1, 0x80, 0x06}, make([]byte, 0x8006)...))
+	f.Add([]byte{0, 0})
+	f.Fuzz(func(t *testing.T, b []byte) {
+		msg, err := readFeedbackMessage(bytes.NewReader(b))
+		if err != nil {
+			return
+		}
+		if size := int(b[4])<<8 | int(b[5]); len(msg.DeviceToken) != 2*size || size > MAX_DEVICE_TOKEN_SIZE {
+			t.Errorf("Token of %d bytes read as %s", size, msg.DeviceToken)
+		}
+	})
+}
@@ -0,0 +1,86 @@
+package apns
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// FeedbackWebhook POSTs feedback messages as JSON to a backend endpoint:
+//
+//	{"token": "<hex device token>", "timestamp": <unix seconds>}
+//
+// so token invalidation can be handled without writing a Go consumer.
+type FeedbackWebhook struct {
+	URL        string
+	Client     *http.Client  // http.DefaultClient if nil
+	MaxRetries int           // attempts after the first failed one
+	RetryDelay time.Duration // grows linearly with every retry
+}
+
+type feedbackWebhookBody struct {
+	Token     string `json:"token"`
+	Timestamp int32  `json:"timestamp"`
+}
+
+// NewFeedbackWebhook creates a webhook retrying 3 times, 5, 10 and 15 seconds apart.
+func NewFeedbackWebhook(url string) *FeedbackWebhook {
+	return &FeedbackWebhook{
+		URL:        url,
+		MaxRetries: 3,
+		RetryDelay: 5 * time.Second,
+	}
+}
+
+func (hook *FeedbackWebhook) post(body []byte) (retry bool, err error) {
+	client := hook.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Post(hook.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 500 {
+		return true, fmt.Errorf("Feedback webhook returned %s", resp.Status)
+	}
+	if resp.StatusCode >= 300 {
+		return false, fmt.Errorf("Feedback webhook returned %s", resp.Status)
+	}
+	return false, nil
+}
+
+// Post delivers msg to the webhook. Network errors and 5xx responses are
+// retried up to MaxRetries times, other non-2xx responses are not.
+func (hook *FeedbackWebhook) Post(msg *ApnsFeedbackMessage) error {
+	body, err := json.Marshal(feedbackWebhookBody{Token: msg.DeviceToken, Timestamp: msg.Time_t})
+	if err != nil {
+		return err
+	}
+
+	for attempt := 0; ; attempt++ {
+		retry, err := hook.post(body)
+		if err == nil || !retry || attempt >= hook.MaxRetries {
+			return err
+		}
+		time.Sleep(hook.RetryDelay * time.Duration(attempt+1))
+	}
+}
+
+// Forward posts every message received on messages until the channel is
+// closed, typically the one returned by StartListening. Messages that
+// cannot be delivered are logged and dropped.
+func (hook *FeedbackWebhook) Forward(messages <-chan *ApnsFeedbackMessage) {
+	for msg := range messages {
+		err := hook.Post(msg)
+		if err != nil {
+			log.Printf("Feedback: could not post token %s to webhook: %v", msg.DeviceToken, err)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package apns
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_FeedbackWebhook(t *testing.T) {
+	calls := 0
+	var got feedbackWebhookBody
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer server.Close()
+
+	hook := NewFeedbackWebhook(server.URL)
+	hook.RetryDelay = 0
+
+	err := hook.Post(&ApnsFeedbackMessage{Time_t: 1349000000, DeviceToken: "0a0b0c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 {
+		t.Errorf("Expected a retry after a 503, got %d calls", calls)
+	}
+	if got.Token != "0a0b0c" || got.Timestamp != 1349000000 {
+		t.Errorf("Invalid body posted: %+v", got)
+	}
+
+	hook.URL = server.URL + "/missing"
+	server.Config.Handler = http.NotFoundHandler()
+	calls = 0
+	if err = hook.Post(&ApnsFeedbackMessage{DeviceToken: "0a"}); err == nil {
+		t.Error("A 404 should be reported as an error")
+	}
+}
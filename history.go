@@ -0,0 +1,141 @@
+package apns
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sentHistory remembers the last notifications written, in a ring buffer
+// of size entries, SENT_HISTORY_SIZE if 0. Once full the oldest entry is
+// forgotten. It matches the identifiers of error responses with device
+// tokens, and is the resend buffer of pipelined connections.
+type sentHistory struct {
+	mu    sync.Mutex
+	size  int
+	buf   []sentNotification
+	start int // oldest entry
+	count int
+}
+
+type sentNotification struct {
+	id     uint32
+	token  []byte
+	packet []byte // kept to resend it when pipelined
+	at     time.Time
+}
+
+func (h *sentHistory) at(i int) sentNotification {
+	return h.buf[(h.start+i)%len(h.buf)]
+}
+
+func (h *sentHistory) dropOldest() {
+	h.buf[h.start] = sentNotification{}
+	h.start = (h.start + 1) % len(h.buf)
+	h.count--
+}
+
+func (h *sentHistory) track(n sentNotification) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.buf == nil {
+		if h.size == 0 {
+			h.size = SENT_HISTORY_SIZE
+		}
+		h.buf = make([]sentNotification, h.size)
+	}
+	if h.count == len(h.buf) {
+		h.dropOldest()
+	}
+	h.buf[(h.start+h.count)%len(h.buf)] = n
+	h.count++
+}
+
+// untrack forgets the last notification if it is id, when it could not be
+// written.
+func (h *sentHistory) untrack(id uint32) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.count > 0 && h.at(h.count-1).id == id {
+		h.count--
+		h.buf[(h.start+h.count)%len(h.buf)] = sentNotification{}
+	}
+}
+
+func (h *sentHistory) lookup(id uint32) (sentNotification, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i := h.count - 1; i >= 0; i-- {
+		if n := h.at(i); n.id == id {
+			return n, true
+		}
+	}
+	return sentNotification{}, false
+}
+
+// after returns the notifications written after id. When id was already
+// forgotten, all of them are more recent and are returned.
+func (h *sentHistory) after(id uint32) []sentNotification {
+	notifications, _ := h.following(id)
+	return notifications
+}
+
+// following returns the notifications written after id, all of them and
+// false if id was already forgotten.
+func (h *sentHistory) following(id uint32) ([]sentNotification, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	first, found := 0, false
+	for i := h.count - 1; i >= 0; i-- {
+		if h.at(i).id == id {
+			first, found = i+1, true
+			break
+		}
+	}
+	notifications := make([]sentNotification, 0, h.count-first)
+	for i := first; i < h.count; i++ {
+		notifications = append(notifications, h.at(i))
+	}
+	return notifications, found
+}
+
+// unsettled returns the notifications written less than settle ago.
+func (h *sentHistory) unsettled(settle time.Duration) []sentNotification {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var notifications []sentNotification
+	for i := 0; i < h.count; i++ {
+		if n := h.at(i); time.Since(n.at) < settle {
+			notifications = append(notifications, n)
+		}
+	}
+	return notifications
+}
+
+// reserve makes room for one more notification, dropping the oldest once
+// settle passed without an error response, and waits while the history is
+// full of more recent ones.
+func (h *sentHistory) reserve(ctx context.Context, settle time.Duration) error {
+	for {
+		h.mu.Lock()
+		if h.count < h.size || h.buf == nil {
+			h.mu.Unlock()
+			return nil
+		}
+		wait := time.Until(h.at(0).at.Add(settle))
+		if wait <= 0 {
+			h.dropOldest()
+			h.mu.Unlock()
+			return nil
+		}
+		h.mu.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
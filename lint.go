@@ -0,0 +1,78 @@
+package apns
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// apsKeys are the keys Apple documents inside aps.
+var apsKeys = map[string]bool{
+	"alert": true, "badge": true, "sound": true, "thread-id": true,
+	"category": true, "content-available": true, "mutable-content": true,
+	"target-content-id": true, "interruption-level": true,
+	"relevance-score": true, "filter-criteria": true, "url-args": true,
+	"event": true, "content-state": true, "timestamp": true,
+	"dismissal-date": true, "stale-date": true,
+	"attributes-type": true, "attributes": true,
+}
+
+// alertKeys are the keys Apple documents inside an alert dictionary.
+var alertKeys = map[string]bool{
+	"title": true, "subtitle": true, "body": true, "launch-image": true,
+	"title-loc-key": true, "title-loc-args": true,
+	"subtitle-loc-key": true, "subtitle-loc-args": true,
+	"loc-key": true, "loc-args": true, "action-loc-key": true, "action": true,
+}
+
+// LintPayload flags keys inside aps and its alert dictionary that Apple
+// does not know, such as content_available for content-available. Apple
+// ignores them silently, so the notification is not what was meant.
+// Payloads without aps pass.
+func LintPayload(payload []byte) error {
+	var doc struct {
+		Aps json.RawMessage `json:"aps"`
+	}
+	err := json.Unmarshal(payload, &doc)
+	if err != nil {
+		return err
+	}
+	if doc.Aps == nil {
+		return nil
+	}
+
+	var aps map[string]json.RawMessage
+	err = json.Unmarshal(doc.Aps, &aps)
+	if err != nil {
+		return errors.New("aps is not a dictionary")
+	}
+
+	problems := unknownKeys("aps", aps, apsKeys)
+	var alert map[string]json.RawMessage
+	if json.Unmarshal(aps["alert"], &alert) == nil {
+		problems = append(problems, unknownKeys("aps.alert", alert, alertKeys)...)
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func unknownKeys(where string, dict map[string]json.RawMessage, known map[string]bool) []string {
+	var problems []string
+	for key := range dict {
+		if known[key] {
+			continue
+		}
+		problem := fmt.Sprintf("Unknown key %q in %s", key, where)
+		// the usual mistakes are case and underscores
+		if suggestion := strings.ReplaceAll(strings.ToLower(key), "_", "-"); known[suggestion] {
+			problem += fmt.Sprintf(", did you mean %q?", suggestion)
+		}
+		problems = append(problems, problem)
+	}
+	sort.Strings(problems)
+	return problems
+}
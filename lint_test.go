@@ -0,0 +1,37 @@
+package apns
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_LintPayload(t *testing.T) {
+	valid := []string{
+		`{"aps":{"alert":{"title":"Hi","loc-key":"K"},"badge":1,"thread-id":"t"},"custom_key":1}`,
+		`{"aps":{"alert":"Hi"}}`,
+		`{"mdm":"magic"}`,
+	}
+	for _, payload := range valid {
+		if err := LintPayload([]byte(payload)); err != nil {
+			t.Errorf("Valid payload flagged: %s: %v", payload, err)
+		}
+	}
+
+	err := LintPayload([]byte(`{"aps":{"content_available":1,"alert":{"Title":"Hi"}}}`))
+	if err == nil {
+		t.Fatal("Misspelled keys accepted")
+	}
+	for _, problem := range []string{`did you mean "content-available"`, `did you mean "title"`} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("Problem not reported: %s in %v", problem, err)
+		}
+	}
+}
+
+func Test_StrictPayload(t *testing.T) {
+	client := &ApnsConn{StrictPayload: true}
+	_, err := client.Send(&Notification{DeviceToken: "0a0b", Payload: []byte(`{"aps":{"sound_name":"a"}}`)})
+	if err == nil || !strings.Contains(err.Error(), "sound_name") {
+		t.Errorf("Expected the lint error, got %v", err)
+	}
+}
@@ -0,0 +1,115 @@
+package apns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// ErrUnknownTopic is returned by ApnsManager sends for topics without a
+// client.
+var ErrUnknownTopic = errors.New("No client for the topic")
+
+// ApnsManager routes notifications to one client per app, keyed by topic,
+// the bundle id of the app. Clients added without their own setting share
+// the manager's TokenStore, BillingSink and Retry policy.
+type ApnsManager struct {
+	TokenStore  TokenStore
+	BillingSink BillingSink
+	Retry       *RetryPolicy
+
+	mu      sync.RWMutex
+	clients map[string]*ApnsConn
+}
+
+func NewManager() *ApnsManager {
+	return &ApnsManager{clients: make(map[string]*ApnsConn)}
+}
+
+// Add registers client for topic, replacing and returning the previous
+// client, if any. An empty topic is read from the client certificate.
+func (m *ApnsManager) Add(topic string, client *ApnsConn) (*ApnsConn, error) {
+	if topic == "" {
+		topic = client.certificateTopic()
+		if topic == "" {
+			return nil, errors.New("Certificate has no topic, pass it to Add")
+		}
+	}
+
+	if client.TokenStore == nil {
+		client.TokenStore = m.TokenStore
+	}
+	if client.BillingSink == nil {
+		client.BillingSink = m.BillingSink
+	}
+	if client.Retry == nil {
+		client.Retry = m.Retry
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	previous := m.clients[topic]
+	m.clients[topic] = client
+	return previous, nil
+}
+
+// Remove unregisters and returns the client for topic, without closing it.
+func (m *ApnsManager) Remove(topic string) *ApnsConn {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	client := m.clients[topic]
+	delete(m.clients, topic)
+	return client
+}
+
+// Client returns the client for topic.
+func (m *ApnsManager) Client(topic string) (*ApnsConn, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	client, found := m.clients[topic]
+	return client, found
+}
+
+// Topics lists the registered topics, sorted.
+func (m *ApnsManager) Topics() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	topics := make([]string, 0, len(m.clients))
+	for topic := range m.clients {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+// Send delivers n with the client for topic, see SendContext.
+func (m *ApnsManager) Send(topic string, n *Notification) (*Response, error) {
+	return m.SendContext(context.Background(), topic, n)
+}
+
+// SendContext delivers n with the client for topic, failing with
+// ErrUnknownTopic if there is none.
+func (m *ApnsManager) SendContext(ctx context.Context, topic string, n *Notification) (*Response, error) {
+	client, found := m.Client(topic)
+	if !found {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownTopic, topic)
+	}
+	return client.SendContext(ctx, n)
+}
+
+// Close closes every client, returning the first error.
+func (m *ApnsManager) Close(ctx context.Context) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var first error
+	for _, client := range m.clients {
+		err := client.Close(ctx)
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
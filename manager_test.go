@@ -0,0 +1,55 @@
+package apns
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func Test_ApnsManager(t *testing.T) {
+	dialed := map[string]int{}
+	newClient := func(name string) *ApnsConn {
+		return &ApnsConn{ReadTimeout: 10 * time.Millisecond, Transport: servedTransport(t, func(server net.Conn, _ int) {
+			dialed[name]++
+			io.Copy(io.Discard, server)
+		})}
+	}
+
+	store := NewMemoryTokenStore()
+	m := NewManager()
+	m.TokenStore = store
+	m.Add("com.example.news", newClient("news"))
+	m.Add("com.example.chat", newClient("chat"))
+
+	n := &Notification{DeviceToken: "0a0b0c", Payload: []byte("{}")}
+	_, err := m.Send("com.example.chat", n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dialed["chat"] != 1 || dialed["news"] != 0 {
+		t.Errorf("Notification routed to the wrong client: %v", dialed)
+	}
+
+	_, err = m.Send("com.example.mail", n)
+	if !errors.Is(err, ErrUnknownTopic) {
+		t.Errorf("Expected ErrUnknownTopic, got %v", err)
+	}
+
+	if client, _ := m.Client("com.example.news"); client.TokenStore != store {
+		t.Error("Client does not share the manager TokenStore")
+	}
+
+	if _, err = m.Add("", newClient("mail")); err == nil {
+		t.Error("Client without topic accepted")
+	}
+
+	if err = m.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = m.Send("com.example.news", n); err != ErrClientClosed {
+		t.Errorf("Expected ErrClientClosed, got %v", err)
+	}
+}
@@ -0,0 +1,24 @@
+package apns
+
+import "encoding/json"
+
+// MDM_TOPIC_PREFIX starts the topic of MDM push certificates.
+const MDM_TOPIC_PREFIX = "com.apple.mgmt."
+
+// NewMDMClient creates a client for mobile device management pushes.
+// certificate must be the MDM push certificate, whose topic starts with
+// MDM_TOPIC_PREFIX.
+func NewMDMClient(endpoint, certificate, key string) (*ApnsConn, error) {
+	return newTopicClient(endpoint, certificate, key, MDM_TOPIC_PREFIX, "an MDM push certificate")
+}
+
+// SendMDM wakes up a managed device so that it connects to the MDM server.
+// token is the hex device token and pushMagic the PushMagic string, both
+// from the device's TokenUpdate check-in.
+func (client *ApnsConn) SendMDM(token, pushMagic string) (*Response, error) {
+	payload, err := json.Marshal(map[string]string{"mdm": pushMagic})
+	if err != nil {
+		return nil, err
+	}
+	return client.Send(&Notification{DeviceToken: token, Payload: payload})
+}
@@ -0,0 +1,22 @@
+package apns
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func Test_SendMDM(t *testing.T) {
+	transport, received := pipeTransport(t)
+	client := &ApnsConn{ReadTimeout: 10 * time.Millisecond, Transport: transport}
+
+	resp, err := client.SendMDM("0a0b0c", "9F4C1A")
+	if err != nil || !resp.Accepted() {
+		t.Fatalf("MDM push failed: %v %v", resp, err)
+	}
+	pkt := <-received
+	payload := []byte(`{"mdm":"9F4C1A"}`)
+	if !bytes.Contains(pkt, append([]byte{2, 0, byte(len(payload))}, payload...)) {
+		t.Errorf("Unexpected packet % x", pkt)
+	}
+}
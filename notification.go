@@ -0,0 +1,174 @@
+package apns
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"time"
+)
+
+// Notification priorities.
+const (
+	// PRIORITY_IMMEDIATE delivers the notification right away. It must
+	// trigger an alert, sound or badge on the device.
+	PRIORITY_IMMEDIATE uint8 = 10
+	// PRIORITY_CONSERVE_POWER lets the device group deliveries to save
+	// power. Required for silent (content-available only) pushes.
+	PRIORITY_CONSERVE_POWER uint8 = 5
+)
+
+// Sender sends notifications. *ApnsConn implements it, code depending on
+// Sender rather than the client can replace it with a mock in tests.
+type Sender interface {
+	SendContext(ctx context.Context, n *Notification) (*Response, error)
+}
+
+var _ Sender = (*ApnsConn)(nil)
+
+// Notification is a push to a single device.
+type Notification struct {
+	DeviceToken string        // hex encoded, as found on the device
+	Payload     []byte        // JSON, see Payload
+	Expiration  time.Duration // from now, Apple stops retrying afterwards
+	Priority    uint8         // PRIORITY_ constant, 0 for Apple's default (immediate)
+	Identifier  uint32        // reported back in error responses, 0 assigns the next one
+
+	// CollapseID replaces a SendAsync notification to the same device
+	// with the same CollapseID still queued, which fails with
+	// ErrCollapsed. It is not sent: the binary protocol has no collapse
+	// identifier.
+	CollapseID string
+
+	// ReadTimeout overrides the client's wait for an error response when
+	// not 0. NO_READ_WAIT returns as soon as the notification is written:
+	// a rejection is then read by a later send and reported on Errors and
+	// OnTokenInvalid for this notification, while the later send, which
+	// Apple dropped, fails with ErrDroppedAfterRejection.
+	ReadTimeout time.Duration
+}
+
+// TokenBucket spreads device tokens over n buckets, always putting the same
+// token in the same one, e.g. to pick the variant of an experiment in
+// BeforeSend.
+func TokenBucket(token string, n int) int {
+	h := fnv.New32a()
+	h.Write([]byte(strings.ToLower(token)))
+	return int(h.Sum32() % uint32(n))
+}
+
+// NO_READ_WAIT as Notification.ReadTimeout skips waiting for an error
+// response.
+const NO_READ_WAIT time.Duration = -1
+
+// Response is the outcome of a notification written to the gateway.
+// The binary protocol only answers failures: Status is StatusNoErrors when
+// no error response arrived within the read window.
+type Response struct {
+	Identifier uint32 // identifier the notification was sent with
+	Status     Status
+
+	// FailedIdentifier is the identifier in the gateway's error response,
+	// 0 if there was none. It names the notification that failed, which
+	// is not always the one just sent: with StatusShutdown it is the last
+	// notification Apple accepted.
+	FailedIdentifier uint32
+}
+
+// Accepted reports whether the gateway did not reject the notification.
+func (r *Response) Accepted() bool {
+	return r.Status == StatusNoErrors
+}
+
+// Reason is the text of Status.
+func (r *Response) Reason() string {
+	return r.Status.String()
+}
+
+// isSilentPayload reports whether payload only asks for a background
+// update: content-available without alert, badge or sound.
+func isSilentPayload(payload []byte) bool {
+	var doc struct {
+		Aps map[string]interface{} `json:"aps"`
+	}
+	if json.Unmarshal(payload, &doc) != nil || doc.Aps == nil {
+		return false
+	}
+	if available, _ := doc.Aps["content-available"].(float64); available != 1 {
+		return false
+	}
+	for _, key := range []string{"alert", "badge", "sound"} {
+		if _, found := doc.Aps[key]; found {
+			return false
+		}
+	}
+	return true
+}
+
+func (n *Notification) validatePriority() error {
+	switch n.Priority {
+	case 0, PRIORITY_IMMEDIATE:
+		if isSilentPayload(n.Payload) {
+			return errors.New("Silent notifications must use PRIORITY_CONSERVE_POWER")
+		}
+	case PRIORITY_CONSERVE_POWER:
+	default:
+		return errors.New("Priority must be PRIORITY_IMMEDIATE or PRIORITY_CONSERVE_POWER")
+	}
+	return nil
+}
+
+// Device token sizes, in bytes: 32 for classic tokens, newer devices may
+// have longer ones.
+const (
+	DEVICE_TOKEN_SIZE     = 32
+	MAX_DEVICE_TOKEN_SIZE = 100
+)
+
+// Validate checks n before sending: token, payload JSON, expiration and
+// priority. It returns every violation found, not just the first. The
+// binary protocol has no topic, it is the one of the client certificate.
+func (n *Notification) Validate() error {
+	var errs []error
+
+	token, err := hex.DecodeString(n.DeviceToken)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("Device token is not hex: %v", err))
+	} else if len(token) < DEVICE_TOKEN_SIZE || len(token) > MAX_DEVICE_TOKEN_SIZE {
+		errs = append(errs, fmt.Errorf("Device token is %d bytes, must be %d to %d", len(token), DEVICE_TOKEN_SIZE, MAX_DEVICE_TOKEN_SIZE))
+	}
+
+	var doc map[string]json.RawMessage
+	if len(n.Payload) == 0 {
+		errs = append(errs, errors.New("Payload is empty"))
+	} else if err := json.Unmarshal(n.Payload, &doc); err != nil {
+		errs = append(errs, fmt.Errorf("Payload is not a JSON object: %v", err))
+	}
+
+	if n.Expiration < 0 {
+		errs = append(errs, errors.New("Expiration must not be negative"))
+	}
+
+	err = n.validatePriority()
+	if err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// Hash identifies the content of n, its device token, payload and
+// CollapseID, as hex. It is stable across processes, e.g. to key
+// idempotent delivery records.
+func (n *Notification) Hash() string {
+	h := sha256.New()
+	for _, field := range [][]byte{[]byte(strings.ToLower(n.DeviceToken)), n.Payload, []byte(n.CollapseID)} {
+		binary.Write(h, binary.BigEndian, uint32(len(field)))
+		h.Write(field)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,126 @@
+package apns
+
+import (
+	"crypto/tls"
+	"time"
+)
+
+// Option configures a client created by NewClientWithOptions.
+type Option func(client *ApnsConn) error
+
+// NewClientWithOptions creates a client for endpoint configured by opts,
+// applied in order. Without options it has no certificate and a 150ms
+// ReadTimeout, like NewClient.
+func NewClientWithOptions(endpoint string, opts ...Option) (*ApnsConn, error) {
+	client := &ApnsConn{
+		tls_cfg:     &tls.Config{InsecureSkipVerify: true},
+		endpoint:    endpoint,
+		ReadTimeout: 150 * time.Millisecond,
+	}
+	for _, opt := range opts {
+		err := opt(client)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return client, nil
+}
+
+// WithCertificate sets the client certificate, checked against the
+// endpoint.
+func WithCertificate(cert tls.Certificate) Option {
+	return func(client *ApnsConn) error {
+		err := checkCertificate(&cert, client.endpoint, client.now())
+		if err != nil {
+			return err
+		}
+		client.tls_cfg.Certificates = []tls.Certificate{cert}
+		return nil
+	}
+}
+
+// WithCertificateFiles loads the client certificate from PEM files.
+func WithCertificateFiles(certificate, key string) Option {
+	return func(client *ApnsConn) error {
+		cert, err := tls.LoadX509KeyPair(certificate, key)
+		if err != nil {
+			return err
+		}
+		return WithCertificate(cert)(client)
+	}
+}
+
+// WithTransport sets the Transport used to open connections.
+func WithTransport(transport Transport) Option {
+	return func(client *ApnsConn) error {
+		client.Transport = transport
+		return nil
+	}
+}
+
+// WithReadTimeout sets how long sends wait for an error response.
+func WithReadTimeout(timeout time.Duration) Option {
+	return func(client *ApnsConn) error {
+		client.ReadTimeout = timeout
+		return nil
+	}
+}
+
+// WithConnectionLimits sets MaxIdle and MaxConnAge.
+func WithConnectionLimits(maxIdle, maxAge time.Duration) Option {
+	return func(client *ApnsConn) error {
+		client.MaxIdle = maxIdle
+		client.MaxConnAge = maxAge
+		return nil
+	}
+}
+
+// WithRetry sets the RetryPolicy of transient failures.
+func WithRetry(policy *RetryPolicy) Option {
+	return func(client *ApnsConn) error {
+		client.Retry = policy
+		return nil
+	}
+}
+
+// WithTokenStore sets the TokenStore recording invalid tokens.
+func WithTokenStore(store TokenStore) Option {
+	return func(client *ApnsConn) error {
+		client.TokenStore = store
+		return nil
+	}
+}
+
+// WithBillingSink sets the BillingSink and the Tenant of its records.
+func WithBillingSink(sink BillingSink, tenant string) Option {
+	return func(client *ApnsConn) error {
+		client.BillingSink = sink
+		client.Tenant = tenant
+		return nil
+	}
+}
+
+// WithVoIP raises the payload limit for a VoIP Services certificate.
+func WithVoIP() Option {
+	return func(client *ApnsConn) error {
+		client.VoIP = true
+		return nil
+	}
+}
+
+// WithStrictPayload refuses payloads flagged by LintPayload.
+func WithStrictPayload() Option {
+	return func(client *ApnsConn) error {
+		client.StrictPayload = true
+		return nil
+	}
+}
+
+// WithClock must come before the certificate options for them to check
+// the certificate against it.
+func WithClock(clock Clock) Option {
+	return func(client *ApnsConn) error {
+		client.Clock = clock
+		return nil
+	}
+}
@@ -0,0 +1,28 @@
+package apns
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_NewClientWithOptions(t *testing.T) {
+	now := time.Unix(1349000000, 0)
+	cert := testCertificate(t, "Apple Push Services: app", now.Add(-time.Hour), now.Add(time.Hour))
+	policy := &RetryPolicy{MaxAttempts: 2}
+
+	client, err := NewClientWithOptions("gateway.sandbox.push.apple.com:2195",
+		WithClock(FixedClock(now)),
+		WithCertificate(*cert),
+		WithReadTimeout(time.Second),
+		WithRetry(policy),
+		WithVoIP())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.certificate() == nil || client.ReadTimeout != time.Second || client.Retry != policy || client.MaxPayloadSize() != VOIP_MAX_PAYLOAD_SIZE {
+		t.Errorf("Options not applied: %+v", client)
+	}
+
+	_, err = NewClientWithOptions("gateway.sandbox.push.apple.com:2195", WithCertificate(*cert))
+	expectAuthError(t, err, CertificateExpired)
+}
@@ -0,0 +1,19 @@
+package apns
+
+// PASS_TOPIC_PREFIX starts pass type identifiers, the topic of Wallet
+// pass certificates.
+const PASS_TOPIC_PREFIX = "pass."
+
+// NewPassClient creates a client for Wallet pass updates. certificate must
+// be the pass type ID certificate that signs the passes, whose topic starts
+// with PASS_TOPIC_PREFIX.
+func NewPassClient(endpoint, certificate, key string) (*ApnsConn, error) {
+	return newTopicClient(endpoint, certificate, key, PASS_TOPIC_PREFIX, "a pass type ID certificate")
+}
+
+// SendPassUpdate tells the device with the hex pushToken, received by the
+// pass web service on registration, that its passes changed. The payload
+// is empty: the device then asks the web service for the updated passes.
+func (client *ApnsConn) SendPassUpdate(pushToken string) (*Response, error) {
+	return client.Send(&Notification{DeviceToken: pushToken, Payload: []byte("{}")})
+}
@@ -0,0 +1,22 @@
+package apns
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func Test_SendPassUpdate(t *testing.T) {
+	transport, received := pipeTransport(t)
+	client := &ApnsConn{ReadTimeout: 10 * time.Millisecond, Transport: transport}
+
+	resp, err := client.SendPassUpdate("0a0b0c")
+	if err != nil || !resp.Accepted() {
+		t.Fatalf("Pass update failed: %v %v", resp, err)
+	}
+	// token item, then a payload item holding {}
+	pkt := <-received
+	if !bytes.Contains(pkt, []byte{1, 0, 3, 0x0a, 0x0b, 0x0c, 2, 0, 2, '{', '}'}) {
+		t.Errorf("Unexpected packet % x", pkt)
+	}
+}
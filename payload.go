@@ -0,0 +1,340 @@
+package apns
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Alert is the dictionary form of the aps alert, used for titles and
+// localized alerts. Empty fields are left out of the JSON.
+type Alert struct {
+	Title           string   `json:"title,omitempty"`
+	Subtitle        string   `json:"subtitle,omitempty"`
+	Body            string   `json:"body,omitempty"`
+	LaunchImage     string   `json:"launch-image,omitempty"`
+	TitleLocKey     string   `json:"title-loc-key,omitempty"`
+	TitleLocArgs    []string `json:"title-loc-args,omitempty"`
+	SubtitleLocKey  string   `json:"subtitle-loc-key,omitempty"`
+	SubtitleLocArgs []string `json:"subtitle-loc-args,omitempty"`
+	LocKey          string   `json:"loc-key,omitempty"`
+	LocArgs         []string `json:"loc-args,omitempty"`
+	ActionLocKey    string   `json:"action-loc-key,omitempty"`
+	Action          string   `json:"action,omitempty"` // Safari button label
+}
+
+type aps struct {
+	Alert            interface{} `json:"alert,omitempty"` // string or *Alert
+	Badge            *int        `json:"badge,omitempty"` // nil leaves the badge untouched, 0 clears it
+	Sound            interface{} `json:"sound,omitempty"` // string or *criticalSound
+	ContentAvailable int         `json:"content-available,omitempty"`
+	MutableContent   int         `json:"mutable-content,omitempty"`
+	ThreadID         string      `json:"thread-id,omitempty"`
+	TargetContentID  string      `json:"target-content-id,omitempty"`
+	URLArgs          interface{} `json:"url-args,omitempty"` // []string, Safari only
+
+	// Live Activities
+	Event         string      `json:"event,omitempty"`
+	ContentState  interface{} `json:"content-state,omitempty"`
+	Timestamp     int64       `json:"timestamp,omitempty"`
+	DismissalDate int64       `json:"dismissal-date,omitempty"`
+}
+
+type criticalSound struct {
+	Critical int     `json:"critical"`
+	Name     string  `json:"name"`
+	Volume   float64 `json:"volume"`
+}
+
+// Payload builds the JSON payload of a notification:
+//
+//	payload := NewPayload()
+//	payload.SetAlert(&Alert{Title: "Game Request", Body: "Bob wants to play poker"})
+//	bytes, err := payload.Encode(MAX_PAYLOAD_SIZE)
+type Payload struct {
+	aps    aps
+	custom map[string]interface{}
+}
+
+func NewPayload() *Payload {
+	return &Payload{}
+}
+
+// SetAlertText sets a plain string alert.
+func (p *Payload) SetAlertText(text string) {
+	p.aps.Alert = text
+}
+
+// SetAlert sets the alert dictionary.
+func (p *Payload) SetAlert(alert *Alert) {
+	p.aps.Alert = alert
+}
+
+// Alert returns the alert dictionary, converting a plain text alert into
+// the dictionary body so that it can be extended.
+func (p *Payload) Alert() *Alert {
+	switch alert := p.aps.Alert.(type) {
+	case *Alert:
+		return alert
+	case string:
+		p.aps.Alert = &Alert{Body: alert}
+	default:
+		p.aps.Alert = &Alert{}
+	}
+	return p.aps.Alert.(*Alert)
+}
+
+// AlertLocalized sets the alert body to the string key of the app's
+// Localizable.strings, formatted on the device with args.
+func (p *Payload) AlertLocalized(key string, args ...string) {
+	alert := p.Alert()
+	alert.LocKey = key
+	alert.LocArgs = args
+}
+
+// AlertTitleLocalized is AlertLocalized for the alert title.
+func (p *Payload) AlertTitleLocalized(key string, args ...string) {
+	alert := p.Alert()
+	alert.TitleLocKey = key
+	alert.TitleLocArgs = args
+}
+
+// AlertSubtitleLocalized is AlertLocalized for the alert subtitle.
+func (p *Payload) AlertSubtitleLocalized(key string, args ...string) {
+	alert := p.Alert()
+	alert.SubtitleLocKey = key
+	alert.SubtitleLocArgs = args
+}
+
+// AlertActionLocalized sets the localized title of the alert's action
+// button.
+func (p *Payload) AlertActionLocalized(key string) {
+	p.Alert().ActionLocKey = key
+}
+
+// SetBadge sets the number displayed on the app icon, 0 clears it.
+func (p *Payload) SetBadge(badge int) {
+	p.aps.Badge = &badge
+}
+
+// ClearBadge removes the badge from the app icon.
+func (p *Payload) ClearBadge() {
+	p.SetBadge(0)
+}
+
+// UnsetBadge leaves the app icon badge as it is.
+func (p *Payload) UnsetBadge() {
+	p.aps.Badge = nil
+}
+
+// SetSound sets the name of a sound in the app bundle, "default" plays the
+// system sound.
+func (p *Payload) SetSound(name string) {
+	p.aps.Sound = name
+}
+
+// SetCriticalSound plays name as a critical alert, bypassing the mute
+// switch and Do Not Disturb, at volume between 0 (silent) and 1 (full).
+// The app needs Apple's critical alerts entitlement.
+func (p *Payload) SetCriticalSound(name string, volume float64) error {
+	if !(volume >= 0 && volume <= 1) {
+		return errors.New("Critical alert volume must be between 0 and 1")
+	}
+	p.aps.Sound = &criticalSound{Critical: 1, Name: name, Volume: volume}
+	return nil
+}
+
+// SetContentAvailable marks the notification as a silent, background
+// update (content-available: 1).
+func (p *Payload) SetContentAvailable() {
+	p.aps.ContentAvailable = 1
+}
+
+// SetMutableContent lets the app's notification service extension modify
+// the notification before it is displayed (mutable-content: 1), e.g. to
+// attach media. The notification needs an alert to be routed through the
+// extension.
+func (p *Payload) SetMutableContent() {
+	p.aps.MutableContent = 1
+}
+
+// SetThreadID groups the notification with the others of the same thread
+// in Notification Center.
+func (p *Payload) SetThreadID(id string) {
+	p.aps.ThreadID = id
+}
+
+// SetTargetContentID selects the app window brought forward when the
+// notification is opened, on iPadOS and macOS.
+func (p *Payload) SetTargetContentID(id string) {
+	p.aps.TargetContentID = id
+}
+
+// Live Activity events.
+const (
+	LIVE_ACTIVITY_START  = "start"
+	LIVE_ACTIVITY_UPDATE = "update"
+	LIVE_ACTIVITY_END    = "end"
+)
+
+// SetLiveActivity makes the payload a Live Activity update: event is one
+// of the LIVE_ACTIVITY_ constants, state is marshaled as the content-state
+// and must match the app's ActivityAttributes.ContentState, timestamp
+// orders updates on the device.
+func (p *Payload) SetLiveActivity(event string, state interface{}, timestamp time.Time) {
+	p.aps.Event = event
+	p.aps.ContentState = state
+	p.aps.Timestamp = timestamp.Unix()
+}
+
+// SetDismissalDate sets when an ended Live Activity is removed from the
+// lock screen.
+func (p *Payload) SetDismissalDate(t time.Time) {
+	p.aps.DismissalDate = t.Unix()
+}
+
+// SetCustom adds a custom top-level key next to aps. value is marshaled
+// as it is, so structs, maps and json.RawMessage can be nested.
+func (p *Payload) SetCustom(key string, value interface{}) error {
+	if key == "aps" {
+		return errors.New("Custom payload keys must not use the aps key")
+	}
+	if p.custom == nil {
+		p.custom = make(map[string]interface{})
+	}
+	p.custom[key] = value
+	return nil
+}
+
+// Custom returns the value of a custom key and whether it is set.
+func (p *Payload) Custom(key string) (interface{}, bool) {
+	value, found := p.custom[key]
+	return value, found
+}
+
+// DeleteCustom removes a custom key.
+func (p *Payload) DeleteCustom(key string) {
+	delete(p.custom, key)
+}
+
+func (p *Payload) MarshalJSON() ([]byte, error) {
+	doc := make(map[string]interface{}, len(p.custom)+1)
+	for key, value := range p.custom {
+		doc[key] = value
+	}
+	doc["aps"] = &p.aps
+	return marshalJSON(doc)
+}
+
+// marshalJSON is json.Marshal without HTML escaping: "<" stays one byte
+// instead of six, so sizes are checked against what Apple counts.
+func marshalJSON(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	err := enc.Encode(v)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// Payload size limits.
+const (
+	// MAX_PAYLOAD_SIZE applies to regular notifications on the binary
+	// gateway.
+	MAX_PAYLOAD_SIZE = 2048
+	// VOIP_MAX_PAYLOAD_SIZE applies to VoIP pushes.
+	VOIP_MAX_PAYLOAD_SIZE = 5120
+)
+
+// PayloadTooLargeError is returned when an encoded payload exceeds the
+// limit of the client.
+type PayloadTooLargeError struct {
+	Size  int
+	Limit int
+}
+
+func (e *PayloadTooLargeError) Error() string {
+	return fmt.Sprintf("The payload is %d bytes, the maximum allowed is %d", e.Size, e.Limit)
+}
+
+// MaxPayloadSize is the payload limit of the client: VOIP_MAX_PAYLOAD_SIZE
+// for VoIP clients, MAX_PAYLOAD_SIZE otherwise.
+func (client *ApnsConn) MaxPayloadSize() int {
+	if client.VoIP {
+		return VOIP_MAX_PAYLOAD_SIZE
+	}
+	return MAX_PAYLOAD_SIZE
+}
+
+// Encode marshals the payload, failing if it is larger than maxSize bytes.
+// Prefer it to json.Marshal, which escapes HTML characters and so can
+// inflate the payload.
+func (p *Payload) Encode(maxSize int) ([]byte, error) {
+	return encodePayload(p, maxSize)
+}
+
+func encodePayload(p json.Marshaler, maxSize int) ([]byte, error) {
+	b, err := p.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > maxSize {
+		return nil, &PayloadTooLargeError{Size: len(b), Limit: maxSize}
+	}
+	return b, nil
+}
+
+// PayloadWithData is a Payload carrying typed custom data. Data must
+// marshal to a JSON object; its keys are placed at the top level of the
+// payload next to aps:
+//
+//	type Chat struct {
+//		ConversationID string `json:"conversation-id"`
+//	}
+//	payload := &PayloadWithData[Chat]{Data: Chat{ConversationID: "42"}}
+//	payload.SetAlertText("New message")
+//	bytes, err := payload.Encode(client.MaxPayloadSize())
+type PayloadWithData[T any] struct {
+	Payload
+	Data T
+}
+
+func (p *PayloadWithData[T]) MarshalJSON() ([]byte, error) {
+	data, err := marshalJSON(p.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc map[string]json.RawMessage
+	err = json.Unmarshal(data, &doc)
+	if err != nil || doc == nil {
+		return nil, errors.New("Custom payload data must encode to a JSON object")
+	}
+	if _, found := doc["aps"]; found {
+		return nil, errors.New("Custom payload data must not use the aps key")
+	}
+	for key, value := range p.custom {
+		if _, found := doc[key]; found {
+			return nil, fmt.Errorf("Custom payload key %q is also set by Data", key)
+		}
+		doc[key], err = marshalJSON(value)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	doc["aps"], err = marshalJSON(&p.aps)
+	if err != nil {
+		return nil, err
+	}
+	return marshalJSON(doc)
+}
+
+// Encode marshals the payload, failing if it is larger than maxSize bytes.
+func (p *PayloadWithData[T]) Encode(maxSize int) ([]byte, error) {
+	return encodePayload(p, maxSize)
+}
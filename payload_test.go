@@ -0,0 +1,171 @@
+package apns
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func expectPayload(t *testing.T, p *Payload, expected string) {
+	t.Helper()
+	b, err := json.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != expected {
+		t.Errorf("Unexpected payload\n got: %s\nwant: %s", b, expected)
+	}
+}
+
+func Test_PayloadAlert(t *testing.T) {
+	p := NewPayload()
+	expectPayload(t, p, `{"aps":{}}`)
+
+	p.SetAlertText("Hello")
+	expectPayload(t, p, `{"aps":{"alert":"Hello"}}`)
+
+	p.Alert().Title = "Greetings"
+	expectPayload(t, p, `{"aps":{"alert":{"title":"Greetings","body":"Hello"}}}`)
+
+	p.SetAlert(&Alert{LocKey: "GAME_PLAY_REQUEST_FORMAT", LocArgs: []string{"Jenna", "Frank"}, LaunchImage: "game.png"})
+	expectPayload(t, p, `{"aps":{"alert":{"launch-image":"game.png","loc-key":"GAME_PLAY_REQUEST_FORMAT","loc-args":["Jenna","Frank"]}}}`)
+}
+
+func Test_PayloadLocalized(t *testing.T) {
+	p := NewPayload()
+	p.AlertTitleLocalized("GAME_TITLE")
+	p.AlertSubtitleLocalized("GAME_SUBTITLE", "Poker")
+	p.AlertLocalized("GAME_PLAY_REQUEST_FORMAT", "Jenna", "Frank")
+	p.AlertActionLocalized("PLAY")
+	expectPayload(t, p, `{"aps":{"alert":{"title-loc-key":"GAME_TITLE","subtitle-loc-key":"GAME_SUBTITLE","subtitle-loc-args":["Poker"],"loc-key":"GAME_PLAY_REQUEST_FORMAT","loc-args":["Jenna","Frank"],"action-loc-key":"PLAY"}}}`)
+}
+
+func Test_PayloadBadgeSound(t *testing.T) {
+	p := NewPayload()
+	p.SetBadge(9)
+	p.SetSound("bingbong.aiff")
+	expectPayload(t, p, `{"aps":{"badge":9,"sound":"bingbong.aiff"}}`)
+
+	p.ClearBadge()
+	expectPayload(t, p, `{"aps":{"badge":0,"sound":"bingbong.aiff"}}`)
+
+	p = NewPayload()
+	p.SetContentAvailable()
+	expectPayload(t, p, `{"aps":{"content-available":1}}`)
+}
+
+func Test_PayloadMutableContent(t *testing.T) {
+	p := NewPayload()
+	p.SetAlertText("New photo")
+	p.SetContentAvailable()
+	p.SetMutableContent()
+	expectPayload(t, p, `{"aps":{"alert":"New photo","content-available":1,"mutable-content":1}}`)
+}
+
+func Test_PayloadThread(t *testing.T) {
+	p := NewPayload()
+	p.SetAlertText("Hi")
+	p.SetThreadID("chat-42")
+	p.SetTargetContentID("window-1")
+	expectPayload(t, p, `{"aps":{"alert":"Hi","thread-id":"chat-42","target-content-id":"window-1"}}`)
+}
+
+func Test_PayloadCriticalSound(t *testing.T) {
+	p := NewPayload()
+	err := p.SetCriticalSound("alarm.caf", 0.75)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectPayload(t, p, `{"aps":{"sound":{"critical":1,"name":"alarm.caf","volume":0.75}}}`)
+
+	if p.SetCriticalSound("alarm.caf", 1.5) == nil {
+		t.Error("Volume above 1 accepted")
+	}
+	if p.SetCriticalSound("alarm.caf", -0.1) == nil {
+		t.Error("Negative volume accepted")
+	}
+}
+
+func Test_PayloadCustom(t *testing.T) {
+	p := NewPayload()
+	p.SetAlertText("Hello")
+	if err := p.SetCustom("aps", 1); err == nil {
+		t.Error("aps custom key accepted")
+	}
+	p.SetCustom("acme", map[string]interface{}{"ids": []int{1, 2}})
+	p.SetCustom("raw", json.RawMessage(`{"a":[true, null]}`))
+	expectPayload(t, p, `{"acme":{"ids":[1,2]},"aps":{"alert":"Hello"},"raw":{"a":[true,null]}}`)
+
+	p.DeleteCustom("raw")
+	if _, found := p.Custom("raw"); found {
+		t.Error("Deleted custom key still set")
+	}
+
+	d := &PayloadWithData[chatData]{Data: chatData{ConversationID: "42"}}
+	d.SetCustom("unread", 1)
+	if _, err := d.Encode(256); err == nil {
+		t.Error("Custom key colliding with Data accepted")
+	}
+}
+
+type chatData struct {
+	ConversationID string `json:"conversation-id"`
+	Unread         int    `json:"unread"`
+}
+
+func Test_PayloadWithData(t *testing.T) {
+	p := &PayloadWithData[chatData]{Data: chatData{ConversationID: "42", Unread: 3}}
+	p.SetAlertText("New message")
+
+	b, err := p.Encode(256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"aps":{"alert":"New message"},"conversation-id":"42","unread":3}`
+	if string(b) != expected {
+		t.Errorf("Unexpected payload %s", b)
+	}
+
+	if _, err = p.Encode(10); err == nil {
+		t.Error("Oversized payload accepted")
+	}
+
+	bad := &PayloadWithData[map[string]int]{Data: map[string]int{"aps": 1}}
+	if _, err = bad.Encode(256); err == nil {
+		t.Error("aps collision accepted")
+	}
+
+	scalar := &PayloadWithData[int]{Data: 1}
+	if _, err = scalar.Encode(256); err == nil {
+		t.Error("Non-object data accepted")
+	}
+}
+
+func Test_PayloadLiveActivity(t *testing.T) {
+	p := NewPayload()
+	p.SetLiveActivity(LIVE_ACTIVITY_UPDATE, map[string]int{"score": 2}, time.Unix(1700000000, 0))
+	expectPayload(t, p, `{"aps":{"event":"update","content-state":{"score":2},"timestamp":1700000000}}`)
+
+	p.SetLiveActivity(LIVE_ACTIVITY_END, map[string]int{"score": 3}, time.Unix(1700000100, 0))
+	p.SetDismissalDate(time.Unix(1700003600, 0))
+	expectPayload(t, p, `{"aps":{"event":"end","content-state":{"score":3},"timestamp":1700000100,"dismissal-date":1700003600}}`)
+}
+
+func Test_PayloadEncodeSize(t *testing.T) {
+	p := NewPayload()
+	p.SetAlertText("<b>Tom & Jerry</b>")
+
+	b, err := p.Encode(MAX_PAYLOAD_SIZE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"aps":{"alert":"<b>Tom & Jerry</b>"}}`
+	if string(b) != expected {
+		t.Errorf("Unexpected payload %s", b)
+	}
+
+	_, err = p.Encode(len(expected) - 1)
+	if err == nil {
+		t.Error("Oversized payload was accepted")
+	}
+}
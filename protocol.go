@@ -1,160 +1,619 @@
 // Package apns provides primitived to communicate with the Apple Notification System.
 // http://developer.apple.com/library/mac/#documentation/NetworkingInternet/Conceptual/RemoteNotificationsPG/Introduction/Introduction.html#//apple_ref/doc/uid/TP40008194-CH1-SW1
 
-// Inspired 
+// Inspired
 // from http://bravenewmethod.wordpress.com/2011/02/25/apple-push-notifications-with-go-language/
 
 package apns
 
 import (
-	"bytes"
+	"bufio"
+	"context"
 	"crypto/tls"
-	"encoding/binary"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"math/rand"
 	"net"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type ApnsConn struct {
-	tlsconn          *tls.Conn
-	tls_cfg          tls.Config
-	endpoint         string
-	ReadTimeout      time.Duration
-	mu               sync.Mutex // Protecting the Apns Channel
-	transactionId    uint32     // keep transaction
-	MAX_PAYLOAD_SIZE int        // default to 256 as per Apple specifications (June 9 2012) 
-	connected        bool
+	conn          net.Conn
+	w             *bufio.Writer // packets are encoded into it, then flushed to conn
+	tls_cfg       *tls.Config
+	endpoint      string
+	Transport     Transport  // used to open connections, DefaultTransport if nil
+	TokenStore    TokenStore // when set invalid tokens are recorded and skipped
+	ReadTimeout   time.Duration
+	mu            sync.Mutex // Protecting the Apns Channel
+	transactionId uint32     // last identifier, accessed atomically
+	VoIP          bool       // VoIP Services certificate, raises the payload limit
+	connected     bool
+	closed        int32         // set by Close, accessed atomically
+	closing       int32         // set by Close before draining SendAsync
+	MaxIdle       time.Duration // reconnect before sending if idle longer, 0 never
+	lastUsed      time.Time
+	MaxConnAge    time.Duration // reconnect before sending once older, 0 never
+	retireAt      time.Time     // MaxConnAge minus jitter
+
+	// PayloadHook receives every payload pretty-printed before it is sent,
+	// with the values of RedactKeys hidden. Meant for development against
+	// the sandbox, it costs a JSON round trip per send.
+	PayloadHook func(token string, payload []byte)
+	RedactKeys  []string
+
+	// DumpPDUs logs every packet written and every error response read as
+	// a hex dump, to diagnose malformed packets. RedactDumps masks the
+	// payload bytes in the dumps.
+	DumpPDUs    bool
+	RedactDumps bool
+
+	// StrictPayload refuses payloads flagged by LintPayload.
+	StrictPayload bool
+
+	// OnTokenInvalid is called with every device token Apple reports
+	// invalid, from error responses or the feedback service.
+	OnTokenInvalid func(token string, at time.Time)
+
+	// RegisteredAt, when set, makes StartListening skip feedback for
+	// tokens registered again after Apple reported them: they are neither
+	// invalidated nor emitted.
+	RegisteredAt RegisteredAtFunc
+
+	// BillingSink receives a BillingRecord for every notification written
+	// to the gateway, tagged with Tenant.
+	BillingSink BillingSink
+	Tenant      string
+	topicOnce   sync.Once
+	topic       string
+
+	// Retry resends notifications that failed for transient reasons, see
+	// IsRetryable. Nil disables retries.
+	Retry *RetryPolicy
+
+	// Clock provides the time for expiration dates, connection ages and
+	// token invalidation, the system clock if nil. Connection deadlines
+	// always use the system clock.
+	Clock Clock
+
+	// OnConnect is called after every new connection to the gateway with
+	// the number of dials it took. When the connection replaces a previous
+	// one, OnReconnect is called next with the reason that one was closed.
+	// OnDisconnect is called when a connection is closed. The hooks run
+	// with the client locked: they must not use it.
+	OnConnect      func(attempt int)
+	OnReconnect    func(reason error, attempt int)
+	OnDisconnect   func(reason error)
+	dialAttempts   int   // failed dials since the last connection
+	lastDisconnect error // reason the last connection was closed
+	hasConnected   bool
+
+	// OnSend is called after every send with its outcome and how long it
+	// took, retries included, e.g. to feed metrics.
+	OnSend func(resp *Response, err error, elapsed time.Duration)
+
+	// BeforeSend may rewrite the notifications of Send, SendContext and
+	// SendAsync before they are validated, e.g. the alert of an A/B test
+	// variant picked with TokenBucket. It gets a copy, the caller's
+	// notification is left as is. An error fails the send.
+	BeforeSend func(n *Notification) error
+
+	// UnsafeAllowUnwrap enables Unwrap. Leave it off unless you are
+	// experimenting with the protocol.
+	UnsafeAllowUnwrap bool
+
+	// MaxQueued bounds the SendAsync notifications not sent yet, beyond
+	// which they fail with ErrQueueFull. SEND_QUEUE_SIZE if 0.
+	MaxQueued int
+
+	// MaxQueuedBytes bounds the token and payload bytes of the SendAsync
+	// notifications not sent yet, unbounded if 0. Beyond it queued ones of
+	// a priority not above the new one are shed, PRIORITY_CONSERVE_POWER
+	// and oldest first, or the new one if that would not make room. Shed
+	// notifications fail with ErrShed and are passed to OnShed.
+	MaxQueuedBytes int
+	OnShed         func(n Notification)
+
+	queue    sendQueue // notifications waiting for SendAsync
+	stats    clientStats
+	history  sentHistory // notifications written, see lateRejection
+	accepted acceptedIds // see RetryPolicy.DedupeWindow
+
+	// BackgroundReader reads error responses on a goroutine per
+	// connection instead of after each send: sends return as soon as the
+	// notification is written, rejections are reported on Errors and
+	// OnTokenInvalid with the identifier and token of the notification,
+	// and dead connections are replaced before the next send. Not for
+	// feedback clients.
+	BackgroundReader bool
+	reader           *connReader
+
+	// Pipelined sends without waiting, like BackgroundReader, and keeps
+	// the notifications written for ReadTimeout in a resend buffer of
+	// PipelineWindow notifications, PIPELINE_WINDOW if 0. Apple drops
+	// what follows a rejected notification on the connection: those are
+	// resent on a new connection. Sends block while the buffer is full.
+	Pipelined      bool
+	PipelineWindow int
+
+	errorsMu sync.Mutex
+	errors   chan *PushError // see Errors
+
+	parent *ApnsConn // the client a Fanout clone reports to
+
+	// FeedbackReconnect sets how StartListening reconnects,
+	// DefaultReconnectPolicy if nil.
+	FeedbackReconnect *ReconnectPolicy
+	feedbackMu        sync.Mutex
+	stopFeedback      func() // stops StartListening
+}
+
+// ErrClientClosed is returned by sends attempted after Close.
+var ErrClientClosed = errors.New("Client is closed")
+
+// Reasons reported to OnDisconnect besides send errors.
+var (
+	ErrConnectionIdle      = errors.New("Connection idle for longer than MaxIdle")
+	ErrConnectionRetired   = errors.New("Connection older than MaxConnAge")
+	ErrCertificateReplaced = errors.New("Client certificate replaced")
+)
+
+// ErrStaleConnection marks sends that failed because a reused connection
+// turned out to be closed by the gateway. They are retried once on a new
+// connection when the write failed, or with Retry.RetryAmbiguous when the
+// notification was written before the connection closed.
+var ErrStaleConnection = errors.New("Stale connection")
+
+// ErrDroppedAfterRejection is returned for a notification Apple discarded
+// because an earlier one, sent with NO_READ_WAIT, was rejected on the same
+// connection. The earlier rejection is reported on Errors and
+// OnTokenInvalid. Sending again is safe.
+var ErrDroppedAfterRejection = errors.New("Dropped after the rejection of an earlier notification")
+
+// ErrUnexpectedResponse is reported when the gateway answers with an error
+// response carrying no error status.
+var ErrUnexpectedResponse = errors.New("Unexpected response without an error status")
+
+// NextIdentifier reserves a notification identifier. Identifiers increase
+// by one and wrap around, skipping 0, which stands for "assign one" in
+// Notification.Identifier. It is safe to call concurrently with sends.
+func (client *ApnsConn) NextIdentifier() uint32 {
+	if client.parent != nil {
+		// unique across the connections of a fanout
+		return client.parent.NextIdentifier()
+	}
+	id := atomic.AddUint32(&client.transactionId, 1)
+	if id == 0 {
+		id = atomic.AddUint32(&client.transactionId, 1)
+	}
+	return id
+}
+
+// certificate is the client certificate, nil if there is none.
+func (client *ApnsConn) certificate() *tls.Certificate {
+	if client.tls_cfg == nil || len(client.tls_cfg.Certificates) == 0 {
+		return nil
+	}
+	return &client.tls_cfg.Certificates[0]
 }
 
 func (client *ApnsConn) connect() (err error) {
+	// APNs silently drops idle connections: do not trust an old one
+	if client.connected && client.MaxIdle > 0 && client.now().Sub(client.lastUsed) > client.MaxIdle {
+		client.disconnect(ErrConnectionIdle)
+	}
+
+	if client.connected && client.MaxConnAge > 0 && client.now().After(client.retireAt) {
+		client.disconnect(ErrConnectionRetired)
+	}
+
 	if client.connected {
 		return nil
 	}
 
-	if client.tlsconn != nil {
+	if client.conn != nil {
 		client.shutdown()
 	}
 
-	conn, err := net.Dial("tcp", client.endpoint)
-
+	err = checkCertificate(client.certificate(), client.endpoint, client.now())
 	if err != nil {
 		return err
 	}
 
-	client.tlsconn = tls.Client(conn, &client.tls_cfg)
+	transport := client.Transport
+	if transport == nil {
+		transport = DefaultTransport
+	}
+
+	config := client.tls_cfg
+	if config == nil {
+		config = &tls.Config{}
+	}
 
-	err = client.tlsconn.Handshake()
+	conn, err := transport.Dial(client.endpoint, config)
 
-	if err == nil {
-		client.connected = true
+	client.dialAttempts++
+	if err != nil {
+		return classifyHandshakeError(err)
 	}
 
-	return err
+	attempt := client.dialAttempts
+	client.dialAttempts = 0
+	if client.OnConnect != nil {
+		client.OnConnect(attempt)
+	}
+	if client.hasConnected {
+		atomic.AddUint64(&client.counters().reconnects, 1)
+		if client.OnReconnect != nil {
+			client.OnReconnect(client.lastDisconnect, attempt)
+		}
+	}
+	client.hasConnected = true
+
+	client.conn = conn
+	if client.w == nil {
+		client.w = bufio.NewWriterSize(conn, packetBufferSize)
+	} else {
+		client.w.Reset(conn)
+	}
+	client.connected = true
+	client.lastUsed = client.now()
+
+	if client.readsInBackground() {
+		client.startReader(conn)
+	}
+
+	if client.MaxConnAge > 0 {
+		// up to 10% jitter so that clients started together do not all
+		// reconnect at the same moment
+		jitter := time.Duration(rand.Int63n(int64(client.MaxConnAge)/10 + 1))
+		client.retireAt = client.lastUsed.Add(client.MaxConnAge - jitter)
+	}
+
+	return nil
 }
 
 // NewClient creates a new apns connection. endpoint and certificate are paths
-// to the X.509 files. 
+// to the X.509 files.
 func NewClient(endpoint, certificate, key string) (*ApnsConn, error) {
+	return NewClientWithOptions(endpoint, WithCertificateFiles(certificate, key))
+}
+
+// VOIP_TOPIC_SUFFIX ends the topic of VoIP Services certificates, after
+// the bundle id.
+const VOIP_TOPIC_SUFFIX = ".voip"
 
-	// load certificates and setup config
-	cert, err := tls.LoadX509KeyPair(certificate, key)
+// NewVoIPClient creates a client for PushKit VoIP pushes, allowing payloads
+// up to VOIP_MAX_PAYLOAD_SIZE. certificate must be a "VoIP Services"
+// certificate, whose topic ends with VOIP_TOPIC_SUFFIX: the certificate,
+// not a topic, selects VoIP delivery on the binary gateway.
+func NewVoIPClient(endpoint, certificate, key string) (*ApnsConn, error) {
+	client, err := NewClient(endpoint, certificate, key)
 	if err != nil {
 		return nil, err
 	}
+	topic := client.certificateTopic()
+	if !strings.HasSuffix(topic, VOIP_TOPIC_SUFFIX) {
+		return nil, errors.New("Certificate is not a VoIP Services certificate, topic " + topic)
+	}
+	client.VoIP = true
+	return client, nil
+}
 
-	apnsConn := &ApnsConn{
-		tlsconn: nil,
-		tls_cfg: tls.Config{
-			InsecureSkipVerify: true,
-			Certificates: []tls.Certificate{cert}},
-		endpoint:         endpoint,
-		ReadTimeout:      150 * time.Millisecond,
-		MAX_PAYLOAD_SIZE: 256,
-		connected:        false,
+// newTopicClient is NewClient for certificates whose topic starts with
+// prefix, described as kind when it does not.
+func newTopicClient(endpoint, certificate, key, prefix, kind string) (*ApnsConn, error) {
+	client, err := NewClient(endpoint, certificate, key)
+	if err != nil {
+		return nil, err
 	}
+	topic := client.certificateTopic()
+	if !strings.HasPrefix(topic, prefix) {
+		return nil, errors.New("Certificate is not " + kind + ", topic " + topic)
+	}
+	return client, nil
+}
 
-	return apnsConn, nil
+// disconnect closes the connection, reporting reason to OnDisconnect if
+// it was open.
+func (client *ApnsConn) disconnect(reason error) error {
+	connected := client.connected
+	err := client.shutdown()
+	if connected {
+		client.lastDisconnect = reason
+		if client.OnDisconnect != nil {
+			client.OnDisconnect(reason)
+		}
+	}
+	return err
 }
 
 func (client *ApnsConn) shutdown() (err error) {
 	err = nil
-	if client.tlsconn != nil {
-		err = client.tlsconn.Close()
+	if client.conn != nil {
+		err = client.conn.Close()
 		client.connected = false
 	}
 	return
 }
 
-// utility function
-func bwrite(w io.Writer, values ...interface{}) (err error) {
-	for _, v := range values {
-		err := binary.Write(w, binary.BigEndian, v)
+// Close stops accepting new sends, sends the notifications queued by
+// SendAsync and waits for their results, waits for the send in progress
+// to complete its error-read window and then closes the connection. With
+// a background reader it first waits for the gateway to close the
+// connection or for the settle time after the last write to pass, so
+// that late rejections are still reported.
+// If ctx expires first Close returns ctx.Err(), the notifications still
+// queued fail with ErrClientClosed and the connection is closed as soon
+// as the pending send is over.
+func (client *ApnsConn) Close(ctx context.Context) error {
+	atomic.StoreInt32(&client.closing, 1)
+
+	done := make(chan error, 1)
+	go func() {
+		client.queue.drain(ctx)
+		atomic.StoreInt32(&client.closed, 1)
+
+		client.mu.Lock()
+		defer client.mu.Unlock()
+		if client.connected && client.readsInBackground() {
+			r := client.reader
+			client.mu.Unlock()
+			client.settle(ctx, r)
+			client.mu.Lock()
+		}
+		done <- client.disconnect(ErrClientClosed)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// pingProbeWindow is how long Ping waits for the gateway to close the
+// connection before considering it alive.
+const pingProbeWindow = 10 * time.Millisecond
+
+// Unwrap returns the connection to the gateway, or nil when not connected
+// or when UnsafeAllowUnwrap is false.
+//
+// This is an escape hatch for protocol experiments (custom keep-alive
+// probes, socket options). Any byte read from or written to the connection
+// behind the client's back can corrupt the frame stream, swallow error
+// responses or lose notifications, and the connection may be closed and
+// replaced by the client at any time.
+func (client *ApnsConn) Unwrap() net.Conn {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	if !client.UnsafeAllowUnwrap || !client.connected {
+		return nil
+	}
+	return client.conn
+}
+
+// Ping checks that the connection to the gateway is alive, connecting
+// first if needed. The binary protocol has no echo command: the probe is a
+// short read, a live connection times out while a dead one reports EOF or
+// a reset. A failed probe closes the connection so the next send reconnects.
+// An error response to a notification sent with NO_READ_WAIT is reported
+// as a send reading it would, and returned.
+func (client *ApnsConn) Ping(ctx context.Context) (err error) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	if atomic.LoadInt32(&client.closed) != 0 {
+		return ErrClientClosed
+	}
+
+	err = ctx.Err()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
 		if err != nil {
-			return err
+			client.disconnect(err)
+		}
+	}()
+
+	err = client.connect()
+	if err != nil {
+		return err
+	}
+
+	if client.readsInBackground() {
+		// the reader closes dead connections
+		return nil
+	}
+
+	deadline := time.Now().Add(pingProbeWindow)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	client.conn.SetReadDeadline(deadline)
+
+	readb := [6]byte{}
+	n, err := io.ReadFull(client.conn, readb[:])
+	if n > 0 && client.DumpPDUs {
+		client.dumpPDU("received", readb[:n], nil)
+	}
+	if n == 0 {
+		if e2, ok := err.(net.Error); ok && e2.Timeout() {
+			return nil
+		}
+		return err
+	}
+
+	// the answer to an earlier notification sent with NO_READ_WAIT
+	status, failedId, err := parseErrorResponse(readb[:n])
+	if err != nil {
+		return err
+	}
+	if status == StatusNoErrors {
+		return ErrUnexpectedResponse
+	}
+	err = client.lateRejection(status, failedId)
+	client.droppedAfter(failedId, 0)
+	return err
+}
+
+// droppedAfter reports the notifications written after failedId, which
+// Apple dropped, except current whose send reports it. It returns the
+// error of the current send.
+func (client *ApnsConn) droppedAfter(failedId, current uint32) error {
+	err := fmt.Errorf("%w: notification %d", ErrDroppedAfterRejection, failedId)
+	if dropped, found := client.history.following(failedId); found {
+		for _, n := range dropped {
+			if n.id != current {
+				client.accepted.remove(n.id)
+				atomic.AddUint64(&client.counters().failed, 1)
+				client.reportError(&PushError{Identifier: n.id, Token: hex.EncodeToString(n.token), Err: err, Time: client.now()})
+			}
 		}
 	}
+	return err
+}
+
+// packetBufferSize fits the largest packet, a command 2 frame with a VoIP
+// payload.
+const packetBufferSize = 8192
+
+// packetWriter is where packets are encoded: the connection's bufio.Writer,
+// or a bytes.Buffer.
+type packetWriter interface {
+	io.Writer
+	io.ByteWriter
+}
+
+func putUint16(w packetWriter, v uint16) {
+	w.WriteByte(byte(v >> 8))
+	w.WriteByte(byte(v))
+}
+
+func putUint32(w packetWriter, v uint32) {
+	putUint16(w, uint16(v>>16))
+	putUint16(w, uint16(v))
+}
+
+// createCommandOnePacket writes an enhanced notification to buf.
+func createCommandOnePacket(buf packetWriter, transactionId uint32, expiration time.Time, token, payload []byte) error {
+
+	expirationTime := uint32(expiration.Unix())
+
+	buf.WriteByte(1)
+	putUint32(buf, transactionId)
+	putUint32(buf, expirationTime)
+	putUint16(buf, uint16(len(token)))
+	buf.Write(token)
+	putUint16(buf, uint16(len(payload)))
+	buf.Write(payload)
+
 	return nil
 }
 
-func createCommandOnePacket(transactionId uint32, expiration time.Duration, token, payload []byte) ([]byte, error) {
+// createCommandZeroPacket writes a simple notification to buf.
+func createCommandZeroPacket(buf packetWriter, transactionId uint32, expiration time.Time, token, payload []byte) error {
+
+	buf.WriteByte(0)
+	putUint16(buf, uint16(len(token)))
+	buf.Write(token)
+	putUint16(buf, uint16(len(payload)))
+	buf.Write(payload)
 
-	expirationTime := uint32(time.Now().In(time.UTC).Add(expiration).Unix())
+	return nil
+}
 
-	// build the actual pdu
-	buffer := bytes.NewBuffer([]byte{})
+// createCommandTwoPacket writes a command 2 frame to buf, the only format
+// that carries a priority. Priority 0 leaves the item out (Apple defaults
+// to 10).
+func createCommandTwoPacket(buf packetWriter, transactionId uint32, expiration time.Time, token, payload []byte, priority uint8) error {
 
-	err := bwrite(buffer, uint8(1),
-		transactionId,
-		expirationTime,
-		uint16(len(token)),
-		token,
-		uint16(len(payload)),
-		payload)
+	expirationTime := uint32(expiration.Unix())
 
-	if err != nil {
-		return nil, err
+	// items: id, length, data
+	frameLen := 3 + len(token) + 3 + len(payload) + 7 + 7
+	if priority != 0 {
+		frameLen += 4
 	}
 
-	pdu := buffer.Bytes()
+	buf.WriteByte(2)
+	putUint32(buf, uint32(frameLen))
+
+	buf.WriteByte(1)
+	putUint16(buf, uint16(len(token)))
+	buf.Write(token)
+
+	buf.WriteByte(2)
+	putUint16(buf, uint16(len(payload)))
+	buf.Write(payload)
+
+	buf.WriteByte(3)
+	putUint16(buf, 4)
+	putUint32(buf, transactionId)
+
+	buf.WriteByte(4)
+	putUint16(buf, 4)
+	putUint32(buf, expirationTime)
+
+	if priority != 0 {
+		buf.WriteByte(5)
+		putUint16(buf, 1)
+		buf.WriteByte(priority)
+	}
 
-	return pdu, nil
+	return nil
 }
 
-func createCommandZeroPacket(transactionId uint32, expiration time.Duration, token, payload []byte) ([]byte, error) {
+// Send delivers n, see SendContext.
+func (client *ApnsConn) Send(n *Notification) (*Response, error) {
+	return client.SendContext(context.Background(), n)
+}
 
-	// build the actual pdu
-	buffer := bytes.NewBuffer([]byte{})
+// SendContext delivers n using the command 2 frame format, which carries
+// the notification priority. It waits for an error response like
+// SendPayloadContext. The Response is set whenever the notification was
+// written, including when the gateway rejected it.
+func (client *ApnsConn) SendContext(ctx context.Context, n *Notification) (*Response, error) {
+	if atomic.LoadInt32(&client.closing) != 0 {
+		return nil, ErrClientClosed
+	}
+	return client.sendNotification(ctx, n)
+}
 
-	err := bwrite(buffer, uint8(0),
-		uint16(len(token)),
-		token,
-		uint16(len(payload)),
-		payload)
+// sendNotification is SendContext, also while Close drains the SendAsync
+// queue.
+func (client *ApnsConn) sendNotification(ctx context.Context, n *Notification) (*Response, error) {
+	if client.BeforeSend != nil {
+		rewritten := *n
+		err := client.BeforeSend(&rewritten)
+		if err != nil {
+			return nil, err
+		}
+		n = &rewritten
+	}
 
+	err := n.validatePriority()
 	if err != nil {
 		return nil, err
 	}
 
-	pdu := buffer.Bytes()
-
-	return pdu, nil
-}
+	token, err := hex.DecodeString(n.DeviceToken)
+	if err != nil {
+		return nil, err
+	}
 
-var errText = map[uint8]string{
-	0:   "No errors encountered",
-	1:   "Processing Errors",
-	2:   "Missing Device Token",
-	3:   "Missing Topic",
-	4:   "Missing Payload",
-	5:   "Invalid Token Size",
-	6:   "Invalid Topic Size",
-	7:   "Invalid Payload Size",
-	8:   "Invalid Token",
-	255: "None (Unknown)",
+	return client.sendWithRetry(ctx, n.Identifier, n.ReadTimeout, token, n.Payload, func(buf packetWriter, transactionId uint32) error {
+		return createCommandTwoPacket(buf, transactionId, client.now().Add(n.Expiration), token, n.Payload, n.Priority)
+	})
 }
 
 // SendPayloadString message to the specified device.
@@ -171,73 +630,221 @@ func (client *ApnsConn) SendPayloadString(token string, payload []byte, expirati
 	return
 }
 
-// SendPayload message to the specified device. 
+// SendPayload message to the specified device.
 // The commands waits for a response for no more that client.ReadTimeout.
 // The method uses the same connection. If the connection is closed it tries to reopen it at the next
-// time. 
+// time.
 func (client *ApnsConn) SendPayload(token, payload []byte, expiration time.Duration) (err error) {
+	return client.SendPayloadContext(context.Background(), token, payload, expiration)
+}
+
+// SendPayloadContext is SendPayload bounded by ctx: its deadline is applied
+// to the connection writes and shortens the error-read window, and
+// cancelling it interrupts a blocked write. A send interrupted before the
+// notification was written returns ctx.Err().
+func (client *ApnsConn) SendPayloadContext(ctx context.Context, token, payload []byte, expiration time.Duration) error {
+	if atomic.LoadInt32(&client.closing) != 0 {
+		return ErrClientClosed
+	}
+	_, err := client.sendWithRetry(ctx, 0, 0, token, payload, func(buf packetWriter, transactionId uint32) error {
+		return createCommandOnePacket(buf, transactionId, client.now().Add(expiration), token, payload)
+	})
+	return err
+}
+
+// send flushes the packet encode writes for identifier id, or the next
+// one if id is 0, and waits for an error response during readTimeout, or
+// the client's ReadTimeout if 0. The response is nil when the notification
+// was not written.
+func (client *ApnsConn) send(ctx context.Context, id uint32, readTimeout time.Duration, token, payload []byte, encode func(w packetWriter, transactionId uint32) error) (resp *Response, err error) {
+
+	if len(payload) > client.MaxPayloadSize() {
+		return nil, &PayloadTooLargeError{Size: len(payload), Limit: client.MaxPayloadSize()}
+	}
+
+	if client.StrictPayload {
+		err := LintPayload(payload)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	if len(payload) > client.MAX_PAYLOAD_SIZE {
-		return errors.New(fmt.Sprintf("The payload exceeds maximum allowed", client.MAX_PAYLOAD_SIZE))
+	if client.TokenStore != nil {
+		valid, err := client.TokenStore.IsValid(hex.EncodeToString(token))
+		if err != nil {
+			return nil, err
+		}
+		if !valid {
+			return nil, ErrTokenInvalid
+		}
 	}
 
 	client.mu.Lock()
 	defer client.mu.Unlock()
+
+	if atomic.LoadInt32(&client.closed) != 0 {
+		return nil, ErrClientClosed
+	}
+
+	err = ctx.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	if client.Pipelined && client.connected {
+		// backpressure, before anything can fail the connection
+		err = client.reader.sent.reserve(ctx, client.settleTime())
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	defer func() {
 		if err != nil {
-			client.shutdown()
+			client.disconnect(err)
+			client.reportSendError(resp, token, err)
 		}
 	}()
 
 	// try to connect
+	previous, wasConnected := client.conn, client.connected
 	err = client.connect()
 	if err != nil {
-		return err
+		return nil, err
+	}
+	reused := wasConnected && client.conn == previous
+
+	deadline, hasDeadline := ctx.Deadline()
+	client.conn.SetWriteDeadline(deadline)
+
+	if ctx.Done() != nil {
+		conn := client.conn
+		done := make(chan struct{})
+		finished := make(chan struct{})
+		go func() {
+			select {
+			case <-ctx.Done():
+				// unblock the pending write or read
+				conn.SetDeadline(time.Now())
+			case <-done:
+			}
+			close(finished)
+		}()
+		defer func() {
+			close(done)
+			<-finished
+		}()
 	}
 
-	client.transactionId++
+	client.debugPayload(hex.EncodeToString(token), payload)
 
-	var pkt []byte
+	if id == 0 {
+		id = client.NextIdentifier()
+	}
+
+	var size int
+	var packet []byte
+	if client.DumpPDUs || client.Pipelined {
+		packet, err = client.encodeBuffered(encode, id, payload)
+	} else {
+		err = encode(client.w, id)
+	}
+	if err == nil {
+		// the caller may reuse its buffer before a late rejection
+		token = append([]byte(nil), token...)
+		client.history.track(sentNotification{id: id, token: token})
+	}
+	if err == nil && client.readsInBackground() {
+		// before the write, the answer can come at once
+		client.reader.sent.track(sentNotification{id: id, token: token, packet: packet, at: time.Now()})
+	}
+	if err == nil {
+		size = client.w.Buffered()
+		err = client.w.Flush()
+		if err != nil && client.readsInBackground() {
+			// failed for the caller, not to be resent
+			client.reader.sent.untrack(id)
+		}
+	}
 
-	pkt, err = createCommandOnePacket(client.transactionId, expiration, token, payload)
 	if err != nil {
+		if hasDeadline && !time.Now().Before(deadline) {
+			// the write deadline and the context expire together
+			<-ctx.Done()
+		}
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		} else if reused && isConnectionClosed(err) {
+			err = fmt.Errorf("%w: %w", ErrStaleConnection, err)
+		}
 		return
 	}
 
-	_, err = client.tlsconn.Write(pkt)
+	client.lastUsed = client.now()
+	if client.readsInBackground() {
+		client.reader.written = time.Now()
+	}
+
+	client.bill(payload, size)
+	atomic.AddUint64(&client.counters().sent, 1)
+	atomic.AddUint64(&client.counters().bytes, uint64(size))
 
-	if err != nil {
-		return
+	resp = &Response{Identifier: id, Status: StatusNoErrors}
+
+	if readTimeout == 0 {
+		readTimeout = client.ReadTimeout
+	}
+	if readTimeout < 0 || client.readsInBackground() {
+		return resp, nil
 	}
 
-	client.tlsconn.SetReadDeadline(time.Now().Add(client.ReadTimeout))
+	readDeadline := time.Now().Add(readTimeout)
+	if hasDeadline && deadline.Before(readDeadline) {
+		readDeadline = deadline
+	}
+	client.conn.SetReadDeadline(readDeadline)
 
 	readb := [6]byte{}
 
-	n, err := client.tlsconn.Read(readb[:])
+	// the response may arrive split across reads
+	n, err := io.ReadFull(client.conn, readb[:])
+	if n > 0 && client.DumpPDUs {
+		client.dumpPDU("received", readb[:n], nil)
+	}
 
-	if err != nil {
+	if n == 0 {
 		if e2, ok := err.(net.Error); ok && e2.Timeout() {
 			err = nil
 			return
-		} else {
-			return err
+		} else if reused && ctx.Err() == nil && isConnectionClosed(err) {
+			// closed without an error response: the gateway dropped the
+			// connection before the notification arrived
+			return resp, fmt.Errorf("%w: %w", ErrStaleConnection, err)
 		}
+		return resp, err
 	}
 
-	if n > 1 {
-		var status uint8 = uint8(readb[1])
-
-		switch status {
-		case 0:
-			// OK
-		case 1, 2, 3, 4, 5, 6, 7, 8, 255:
-			return errors.New(errText[status])
-		default:
-			return errors.New(fmt.Sprintf("Unknown error code %s ", hex.EncodeToString(readb[:n])))
-		}
+	status, failedId, err := parseErrorResponse(readb[:n])
+	if err != nil {
+		return resp, err
 	}
+	resp.FailedIdentifier = failedId
 
-	err = nil
-	return
+	if status == StatusNoErrors {
+		return resp, nil
+	}
+	if failedId != id && status != StatusShutdown {
+		// a late answer for an earlier notification sent with
+		// NO_READ_WAIT: Apple dropped what followed it, this one included
+		client.lateRejection(status, failedId)
+		return resp, client.droppedAfter(failedId, id)
+	}
+	resp.Status = status
+	if status.IsTokenInvalid() {
+		client.tokenInvalid(hex.EncodeToString(token), client.now())
+	}
+	if !status.IsKnown() {
+		return resp, &UnknownStatusError{Status: status, Identifier: resp.FailedIdentifier}
+	}
+	return resp, &StatusError{Status: status, Identifier: resp.FailedIdentifier}
 }
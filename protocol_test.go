@@ -0,0 +1,530 @@
+package apns
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_Close(t *testing.T) {
+	client := &ApnsConn{}
+
+	err := client.Close(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = client.SendPayloadString("0a0b0c", []byte("{}"), time.Hour)
+	if err != ErrClientClosed {
+		t.Errorf("Expected ErrClientClosed, got %v", err)
+	}
+}
+
+func Test_Ping(t *testing.T) {
+	server, transport := pipeConn(t)
+	client := &ApnsConn{Transport: transport}
+
+	err := client.Ping(context.Background())
+	if err != nil {
+		t.Errorf("Ping on an idle connection failed: %v", err)
+	}
+
+	server.Close()
+	err = client.Ping(context.Background())
+	if err == nil {
+		t.Error("Ping on a closed connection succeeded")
+	}
+}
+
+func Test_PingLateRejection(t *testing.T) {
+	server, transport := pipeConn(t)
+	client := &ApnsConn{Transport: transport}
+	errs := client.Errors()
+
+	go func() {
+		b := make([]byte, 256)
+		for i := 0; i < 2; i++ {
+			server.Read(b)
+		}
+		server.Write([]byte{8, byte(StatusInvalidToken), 0, 0, 0, 1})
+	}()
+
+	for i, token := range []string{"aaaa", "bbbb"} {
+		client.Send(&Notification{DeviceToken: token, Payload: []byte("{}"), Identifier: uint32(i + 1), ReadTimeout: NO_READ_WAIT})
+	}
+	time.Sleep(10 * time.Millisecond)
+	var statusErr *StatusError
+	if err := client.Ping(context.Background()); !errors.As(err, &statusErr) || statusErr.Identifier != 1 {
+		t.Fatalf("Expected the rejection of notification 1, got %v", err)
+	}
+
+	for _, id := range []uint32{1, 2} {
+		select {
+		case e := <-errs:
+			if e.Identifier != id {
+				t.Errorf("Expected notification %d, got %+v", id, e)
+			}
+		default:
+			t.Fatalf("Notification %d not reported", id)
+		}
+	}
+	if stats := client.Stats(); stats.Failed != 1 || stats.Rejected[StatusInvalidToken] != 1 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
+
+func Test_NewVoIPClient(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeCertificate(t, dir, topicCertificate(t, "com.example.app.voip"))
+	client, err := NewVoIPClient(APPLE_GATEWAY, certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !client.VoIP || client.MaxPayloadSize() != VOIP_MAX_PAYLOAD_SIZE {
+		t.Error("VoIP client keeps the regular payload limit")
+	}
+
+	certFile, keyFile = writeCertificate(t, dir, topicCertificate(t, "com.example.app"))
+	_, err = NewVoIPClient(APPLE_GATEWAY, certFile, keyFile)
+	if err == nil || !strings.Contains(err.Error(), "com.example.app") {
+		t.Errorf("Expected the topic to be refused, got %v", err)
+	}
+}
+
+func Test_IdleRefresh(t *testing.T) {
+	dials := 0
+	client := &ApnsConn{Transport: TransportFunc(func(endpoint string, config *tls.Config) (net.Conn, error) {
+		dials++
+		_, conn := net.Pipe()
+		return conn, nil
+	}), MaxIdle: time.Minute}
+
+	client.connect()
+	client.connect()
+	if dials != 1 {
+		t.Errorf("Fresh connection was replaced, %d dials", dials)
+	}
+
+	client.lastUsed = time.Now().Add(-2 * time.Minute)
+	client.connect()
+	if dials != 2 {
+		t.Errorf("Idle connection was not replaced, %d dials", dials)
+	}
+}
+
+func Test_SendPayloadContextDeadline(t *testing.T) {
+	_, transport := pipeConn(t)
+	client := &ApnsConn{ReadTimeout: time.Minute, Transport: transport}
+
+	// nobody reads on the other side: the write blocks until the deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := client.SendPayloadContext(ctx, []byte{0x0a}, []byte("{}"), time.Hour)
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected DeadlineExceeded, got %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("Write was not interrupted: %v", time.Since(start))
+	}
+}
+
+func Test_MaxConnAge(t *testing.T) {
+	dials := 0
+	client := &ApnsConn{Transport: TransportFunc(func(endpoint string, config *tls.Config) (net.Conn, error) {
+		dials++
+		_, conn := net.Pipe()
+		return conn, nil
+	}), MaxConnAge: time.Hour}
+
+	client.connect()
+	if client.retireAt.Before(time.Now().Add(54*time.Minute)) || client.retireAt.After(time.Now().Add(time.Hour)) {
+		t.Errorf("Retirement time out of the jitter range: %v", client.retireAt)
+	}
+
+	client.retireAt = time.Now().Add(-time.Second)
+	client.connect()
+	if dials != 2 {
+		t.Errorf("Old connection was not replaced, %d dials", dials)
+	}
+}
+
+func Test_createCommandTwoPacket(t *testing.T) {
+	var buf bytes.Buffer
+	err := createCommandTwoPacket(&buf, 7, time.Unix(1349000000, 0), []byte{0xA, 0xB}, []byte("{}"), PRIORITY_CONSERVE_POWER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkt := buf.Bytes()
+
+	expected := []byte{
+		2, 0, 0, 0, 28, // command, frame length
+		1, 0, 2, 0xA, 0xB, // token
+		2, 0, 2, '{', '}', // payload
+		3, 0, 4, 0, 0, 0, 7, // identifier
+		4, 0, 4, 0x50, 0x68, 0x1b, 0x40, // expiration
+		5, 0, 1, 5, // priority
+	}
+	if !bytes.Equal(pkt, expected) {
+		t.Errorf("Unexpected packet\n got: %v\nwant: %v", pkt, expected)
+	}
+}
+
+func Test_createCommandOnePacket(t *testing.T) {
+	var buf bytes.Buffer
+	createCommandOnePacket(&buf, 7, time.Unix(1349000000, 0), []byte{0xA, 0xB}, []byte("{}"))
+
+	pkt := buf.Bytes()
+	expected := []byte{
+		1,          // command
+		0, 0, 0, 7, // identifier
+		0x50, 0x68, 0x1b, 0x40, // expiration
+		0, 2, 0xA, 0xB, // token
+		0, 2, '{', '}', // payload
+	}
+	if !bytes.Equal(pkt, expected) {
+		t.Errorf("Unexpected packet\n got: %v\nwant: %v", pkt, expected)
+	}
+}
+
+func Test_Clock(t *testing.T) {
+	transport, received := pipeTransport(t)
+	client := &ApnsConn{
+		ReadTimeout: 10 * time.Millisecond,
+		Clock:       FixedClock(time.Unix(1349000000, 0)),
+		Transport:   transport,
+	}
+
+	client.Send(&Notification{DeviceToken: "0a0b", Payload: []byte("{}"), Expiration: time.Hour})
+
+	// expiration item of a command 2 frame without priority
+	expected := []byte{4, 0, 4, 0x50, 0x68, 0x29, 0x50}
+	if pkt := <-received; !bytes.HasSuffix(pkt, expected) {
+		t.Errorf("Unexpected expiration in % x", pkt)
+	}
+}
+
+func Test_BeforeSend(t *testing.T) {
+	transport, received := pipeTransport(t)
+	client := &ApnsConn{ReadTimeout: 10 * time.Millisecond, Transport: transport}
+	variants := []string{`{"aps":{"alert":"A"}}`, `{"aps":{"alert":"B"}}`}
+	client.BeforeSend = func(n *Notification) error {
+		if n.DeviceToken == "0c" {
+			return errors.New("Excluded from the experiment")
+		}
+		n.Payload = []byte(variants[TokenBucket(n.DeviceToken, len(variants))])
+		return nil
+	}
+
+	n := &Notification{DeviceToken: "0a0b", Payload: []byte(`{"aps":{"alert":"hi"}}`)}
+	if _, err := client.Send(n); err != nil {
+		t.Fatal(err)
+	}
+	if pkt := <-received; !bytes.Contains(pkt, []byte(variants[TokenBucket("0A0B", 2)])) {
+		t.Errorf("Variant not sent in %q", pkt)
+	}
+	if string(n.Payload) != `{"aps":{"alert":"hi"}}` {
+		t.Errorf("Caller's notification rewritten: %s", n.Payload)
+	}
+
+	if _, err := client.Send(&Notification{DeviceToken: "0c", Payload: []byte("{}")}); err == nil {
+		t.Error("Send went on after BeforeSend failed")
+	}
+}
+
+func Test_NextIdentifier(t *testing.T) {
+	client := &ApnsConn{transactionId: 0xfffffffe}
+	for _, expected := range []uint32{0xffffffff, 1, 2} {
+		if id := client.NextIdentifier(); id != expected {
+			t.Errorf("Expected identifier %d, got %d", expected, id)
+		}
+	}
+
+	server, transport := pipeConn(t)
+	go io.Copy(io.Discard, server)
+	client.ReadTimeout = 10 * time.Millisecond
+	client.Transport = transport
+	resp, err := client.Send(&Notification{DeviceToken: "0a0b", Payload: []byte("{}"), Identifier: 4242})
+	if err != nil || resp.Identifier != 4242 {
+		t.Errorf("Caller identifier not used: %v %v", resp, err)
+	}
+	if id := client.NextIdentifier(); id != 3 {
+		t.Errorf("Caller identifier consumed the sequence, got %d", id)
+	}
+}
+
+func Test_FailedIdentifier(t *testing.T) {
+	server, transport := pipeConn(t)
+	client := &ApnsConn{ReadTimeout: time.Second, Transport: transport}
+
+	go func() {
+		b := make([]byte, 256)
+		server.Read(b)
+		server.Write([]byte{8, byte(StatusInvalidToken), 0, 0, 0x10, 0x92})
+	}()
+
+	resp, err := client.Send(&Notification{DeviceToken: "0a0b", Payload: []byte("{}"), Identifier: 4242})
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.Identifier != 4242 {
+		t.Errorf("Expected a StatusError for notification 4242, got %v", err)
+	}
+	if resp == nil || resp.FailedIdentifier != 4242 || resp.Status != StatusInvalidToken {
+		t.Errorf("Unexpected response %+v", resp)
+	}
+}
+
+func Test_LateRejectionToken(t *testing.T) {
+	// the rejection of the first notification is read by the second send
+	server, transport := pipeConn(t)
+	client := &ApnsConn{ReadTimeout: time.Second, Transport: transport}
+	var invalid []string
+	client.OnTokenInvalid = func(token string, at time.Time) { invalid = append(invalid, token) }
+	errs := client.Errors()
+
+	go func() {
+		b := make([]byte, 256)
+		server.Read(b)
+		server.Read(b)
+		server.Write([]byte{8, byte(StatusInvalidToken), 0, 0, 0, 1})
+	}()
+
+	client.Send(&Notification{DeviceToken: "aaaa", Payload: []byte("{}"), Identifier: 1, ReadTimeout: NO_READ_WAIT})
+	resp, err := client.Send(&Notification{DeviceToken: "bbbb", Payload: []byte("{}"), Identifier: 2})
+
+	if len(invalid) != 1 || invalid[0] != "aaaa" {
+		t.Errorf("Unexpected invalidated tokens %v", invalid)
+	}
+	if e := <-errs; e.Identifier != 1 || e.Token != "aaaa" || e.Status != StatusInvalidToken {
+		t.Errorf("Rejection reported for the wrong notification: %+v", e)
+	}
+	// Apple dropped the second notification, it was not rejected
+	if !errors.Is(err, ErrDroppedAfterRejection) || resp.Status != StatusNoErrors || resp.FailedIdentifier != 1 {
+		t.Errorf("Unexpected result of the current send: %+v %v", resp, err)
+	}
+	if e := <-errs; e.Identifier != 2 || e.Token != "bbbb" || !errors.Is(e, ErrDroppedAfterRejection) {
+		t.Errorf("Unexpected failure of the current send: %+v", e)
+	}
+}
+
+func Test_LateRejectionDropped(t *testing.T) {
+	// the rejection of the first notification is read by the fourth send,
+	// Apple dropped the two in between too
+	server, transport := pipeConn(t)
+	client := &ApnsConn{ReadTimeout: time.Second, Transport: transport}
+	errs := client.Errors()
+
+	go func() {
+		b := make([]byte, 256)
+		for i := 0; i < 4; i++ {
+			server.Read(b)
+		}
+		server.Write([]byte{8, byte(StatusInvalidToken), 0, 0, 0, 1})
+	}()
+
+	for i, token := range []string{"aaaa", "bbbb", "cccc"} {
+		client.Send(&Notification{DeviceToken: token, Payload: []byte("{}"), Identifier: uint32(i + 1), ReadTimeout: NO_READ_WAIT})
+	}
+	_, err := client.Send(&Notification{DeviceToken: "dddd", Payload: []byte("{}"), Identifier: 4})
+	if !errors.Is(err, ErrDroppedAfterRejection) {
+		t.Fatalf("Expected ErrDroppedAfterRejection, got %v", err)
+	}
+
+	expected := []struct {
+		id    uint32
+		token string
+	}{{1, "aaaa"}, {2, "bbbb"}, {3, "cccc"}, {4, "dddd"}}
+	for _, x := range expected {
+		select {
+		case e := <-errs:
+			if e.Identifier != x.id || e.Token != x.token {
+				t.Errorf("Expected notification %d of %s, got %+v", x.id, x.token, e)
+			}
+		default:
+			t.Fatalf("Notification %d not reported", x.id)
+		}
+	}
+	// the two dropped in between and the current send fail, the first
+	// one counts as rejected
+	if stats := client.Stats(); stats.Failed != 3 || stats.Rejected[StatusInvalidToken] != 1 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
+
+func Test_LateRejectionReusedToken(t *testing.T) {
+	// the gateway rejects the first notification after the caller reused
+	// its token buffer
+	read := make(chan struct{})
+	client := &ApnsConn{BackgroundReader: true, Transport: servedTransport(t, func(server net.Conn, _ int) {
+		b := make([]byte, 256)
+		server.Read(b)
+		<-read
+		server.Write(append([]byte{8, byte(StatusInvalidToken)}, b[1:5]...))
+		server.Close()
+	})}
+	invalid := make(chan string, 1)
+	client.OnTokenInvalid = func(token string, at time.Time) { invalid <- token }
+
+	token := []byte{0xba, 0xd0}
+	if err := client.SendPayload(token, []byte("{}"), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	copy(token, []byte{0x60, 0x0d})
+	close(read)
+
+	select {
+	case got := <-invalid:
+		if got != "bad0" {
+			t.Errorf("Invalidated %s instead of the rejected token", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Rejection not reported")
+	}
+}
+
+func Test_NotificationReadTimeout(t *testing.T) {
+	server, transport := pipeConn(t)
+	go io.Copy(io.Discard, server)
+	client := &ApnsConn{ReadTimeout: time.Hour, Transport: transport}
+
+	start := time.Now()
+	_, err := client.Send(&Notification{DeviceToken: "0a0b", Payload: []byte("{}"), ReadTimeout: NO_READ_WAIT})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.Send(&Notification{DeviceToken: "0a0b", Payload: []byte("{}"), ReadTimeout: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) > 10*time.Second {
+		t.Errorf("Client ReadTimeout was not overridden: %v", time.Since(start))
+	}
+}
+
+func Test_NotificationPriority(t *testing.T) {
+	silent := &Notification{DeviceToken: "0a", Payload: []byte(`{"aps":{"content-available":1}}`)}
+	if silent.validatePriority() == nil {
+		t.Error("Silent push accepted with the default priority")
+	}
+	silent.Priority = PRIORITY_CONSERVE_POWER
+	if err := silent.validatePriority(); err != nil {
+		t.Error(err)
+	}
+
+	alert := &Notification{DeviceToken: "0a", Payload: []byte(`{"aps":{"alert":"hi","content-available":1}}`), Priority: PRIORITY_IMMEDIATE}
+	if err := alert.validatePriority(); err != nil {
+		t.Error(err)
+	}
+	alert.Priority = 1
+	if alert.validatePriority() == nil {
+		t.Error("Priority 1 accepted")
+	}
+}
+
+func Test_NotificationValidate(t *testing.T) {
+	n := &Notification{DeviceToken: strings.Repeat("0a", 32), Payload: []byte(`{"aps":{"alert":"hi"}}`)}
+	if err := n.Validate(); err != nil {
+		t.Error(err)
+	}
+	n.DeviceToken = strings.Repeat("0a", 80)
+	if err := n.Validate(); err != nil {
+		t.Errorf("Long token refused: %v", err)
+	}
+
+	invalid := &Notification{DeviceToken: "0a0b", Payload: []byte(`{"aps":`), Expiration: -time.Second, Priority: 1}
+	err := invalid.Validate()
+	if err == nil {
+		t.Fatal("Invalid notification accepted")
+	}
+	for _, problem := range []string{"Device token", "JSON", "Expiration", "Priority"} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("Violation not reported: %s in %v", problem, err)
+		}
+	}
+}
+
+func Test_NotificationHash(t *testing.T) {
+	n := &Notification{DeviceToken: "0a0b", Payload: []byte("{}"), Identifier: 1}
+	same := &Notification{DeviceToken: "0A0B", Payload: []byte("{}"), Identifier: 2, Expiration: time.Hour}
+	if n.Hash() != same.Hash() {
+		t.Error("Hash depends on more than the token and payload")
+	}
+	if len(n.Hash()) != 64 {
+		t.Errorf("Unexpected hash %s", n.Hash())
+	}
+	// fields are delimited: moving bytes from one to the other changes it
+	if n.Hash() == (&Notification{DeviceToken: "0a", Payload: []byte("0b{}")}).Hash() {
+		t.Error("Same hash for a different token and payload")
+	}
+	if n.Hash() == (&Notification{DeviceToken: "0a0b", Payload: []byte("{}"), CollapseID: "score"}).Hash() {
+		t.Error("Hash ignores CollapseID")
+	}
+}
+
+func Test_Unwrap(t *testing.T) {
+	_, conn := net.Pipe()
+	client := &ApnsConn{Transport: TransportFunc(func(endpoint string, config *tls.Config) (net.Conn, error) {
+		return conn, nil
+	})}
+	client.connect()
+
+	if client.Unwrap() != nil {
+		t.Error("Unwrap returned the connection without UnsafeAllowUnwrap")
+	}
+	client.UnsafeAllowUnwrap = true
+	if client.Unwrap() != conn {
+		t.Error("Unwrap did not return the connection")
+	}
+}
+
+func Test_LifecycleHooks(t *testing.T) {
+	dials := 0
+	var events []string
+	client := &ApnsConn{
+		ReadTimeout: 10 * time.Millisecond,
+		MaxIdle:     time.Minute,
+		Transport: TransportFunc(func(endpoint string, config *tls.Config) (net.Conn, error) {
+			dials++
+			if dials == 2 {
+				return nil, errors.New("connection refused")
+			}
+			server, conn := net.Pipe()
+			go io.Copy(io.Discard, server)
+			return conn, nil
+		}),
+		OnConnect: func(attempt int) {
+			events = append(events, fmt.Sprintf("connect %d", attempt))
+		},
+		OnReconnect: func(reason error, attempt int) {
+			events = append(events, fmt.Sprintf("reconnect %d: %v", attempt, reason))
+		},
+		OnDisconnect: func(reason error) {
+			events = append(events, fmt.Sprintf("disconnect: %v", reason))
+		},
+	}
+
+	n := &Notification{DeviceToken: "0a0b", Payload: []byte("{}")}
+	client.Send(n)
+	client.lastUsed = time.Now().Add(-time.Hour)
+	client.Send(n)
+	client.Send(n)
+	client.Close(context.Background())
+
+	expected := []string{
+		"connect 1",
+		"disconnect: " + ErrConnectionIdle.Error(),
+		"connect 2",
+		"reconnect 2: " + ErrConnectionIdle.Error(),
+		"disconnect: " + ErrClientClosed.Error(),
+	}
+	if strings.Join(events, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Unexpected events\n got: %q\nwant: %q", events, expected)
+	}
+}
@@ -0,0 +1,212 @@
+package apns
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// SENT_HISTORY_SIZE is how many notifications the background reader
+// remembers per connection to match error responses with device tokens.
+const SENT_HISTORY_SIZE = 1024
+
+// PIPELINE_WINDOW is the default size of the resend buffer of pipelined
+// clients.
+const PIPELINE_WINDOW = 1000
+
+// connReader reads the error responses of one connection, see
+// ApnsConn.BackgroundReader.
+type connReader struct {
+	conn    net.Conn
+	sent    *sentHistory  // written on conn, the resend buffer when pipelined
+	written time.Time     // last write, guarded by the client's mu
+	done    chan struct{} // closed when the reader returns
+}
+
+func (client *ApnsConn) readsInBackground() bool {
+	return client.BackgroundReader || client.Pipelined
+}
+
+func (client *ApnsConn) pipelineWindow() int {
+	if client.PipelineWindow > 0 {
+		return client.PipelineWindow
+	}
+	return PIPELINE_WINDOW
+}
+
+// settleTime is how long a pipelined notification is kept for resending.
+func (client *ApnsConn) settleTime() time.Duration {
+	if client.ReadTimeout > 0 {
+		return client.ReadTimeout
+	}
+	return 150 * time.Millisecond
+}
+
+// startReader runs the background reader of a new connection.
+func (client *ApnsConn) startReader(conn net.Conn) {
+	client.reader = &connReader{conn: conn, sent: &sentHistory{}, done: make(chan struct{})}
+	if client.Pipelined {
+		client.reader.sent.size = client.pipelineWindow()
+	}
+	go client.readResponses(client.reader)
+}
+
+// readResponses waits for the error response or the end of r.conn. A
+// rejection is reported on Errors and OnTokenInvalid with the identifier
+// and token of the failed notification, and the notifications Apple
+// dropped after it are resent when pipelined, reported as
+// ErrDroppedAfterRejection otherwise. A connection closed by the gateway
+// without a response is reported as ErrStaleConnection, as are the
+// notifications written within the settle time, unless pipelined with
+// Retry.RetryAmbiguous which resends them. Either way the connection is
+// closed, so that the next send opens a new one instead of writing to a
+// dead connection.
+func (client *ApnsConn) readResponses(r *connReader) {
+	defer close(r.done)
+
+	readb := [6]byte{}
+	n, err := io.ReadFull(r.conn, readb[:])
+	if n > 0 && client.DumpPDUs {
+		client.dumpPDU("received", readb[:n], nil)
+	}
+
+	rejected := false
+	var id uint32
+	if n > 0 {
+		var status Status
+		status, id, err = parseErrorResponse(readb[:n])
+		if err == nil && status != StatusNoErrors {
+			rejected = true
+			err = client.lateRejection(status, id)
+			if status != StatusShutdown {
+				client.queue.resolveLate(id, &Response{Identifier: id, Status: status, FailedIdentifier: id}, err)
+			}
+		} else if err == nil {
+			err = ErrUnexpectedResponse
+		}
+	}
+
+	client.mu.Lock()
+	late := client.closeReader(r, n, rejected, id, err)
+	client.mu.Unlock()
+
+	// callbacks may send again
+	for _, l := range late {
+		l.resolve()
+	}
+}
+
+// closeReader closes the connection of r after its response, or its end
+// when n is 0, and reports or resends the notifications lost with it. It
+// returns the futures to resolve once the client is unlocked. The client
+// must be locked.
+func (client *ApnsConn) closeReader(r *connReader, n int, rejected bool, id uint32, err error) []lateResult {
+	// sends write to r.conn with the client locked: nothing can be added
+	// to the buffer from now on
+	var dropped []sentNotification
+	if rejected {
+		// with StatusShutdown id is the last accepted one
+		dropped = r.sent.after(id)
+	}
+
+	if client.connected && client.conn == r.conn {
+		if !rejected && n == 0 && isConnectionClosed(err) {
+			err = fmt.Errorf("%w: %w", ErrStaleConnection, err)
+			dropped = r.sent.unsettled(client.settleTime())
+		}
+		client.disconnect(err)
+		if !rejected && len(dropped) == 0 {
+			client.reportError(&PushError{Err: err, Time: client.now()})
+			return nil
+		}
+	} else if !rejected {
+		// closed by the client
+		return nil
+	}
+
+	if rejected {
+		err = fmt.Errorf("%w: notification %d", ErrDroppedAfterRejection, id)
+	}
+	if !client.Pipelined || (!rejected && (client.Retry == nil || !client.Retry.RetryAmbiguous)) {
+		// not kept for resending, or may have been delivered
+		late := make([]lateResult, 0, len(dropped))
+		for _, n := range dropped {
+			late = append(late, client.lost(n, err))
+		}
+		return late
+	}
+	return client.resend(dropped)
+}
+
+// lost reports a notification written to the gateway that failed after
+// its send returned. Its future, if any, is returned to be resolved
+// once the client is unlocked.
+func (client *ApnsConn) lost(n sentNotification, err error) lateResult {
+	client.accepted.remove(n.id)
+	client.reportError(&PushError{Identifier: n.id, Token: hex.EncodeToString(n.token), Err: err, Time: client.now()})
+	return lateResult{f: client.queue.take(n.id), resp: &Response{Identifier: n.id}, err: err}
+}
+
+// settle waits until r's connection is closed or until the settle time
+// after its last write has passed. The client must not be locked.
+func (client *ApnsConn) settle(ctx context.Context, r *connReader) {
+	client.mu.Lock()
+	wait := time.Until(r.written.Add(client.settleTime()))
+	client.mu.Unlock()
+	if wait <= 0 {
+		return
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-r.done:
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
+// resend writes again, in order and on a new connection, the
+// notifications Apple dropped. Those that cannot be written are reported
+// on Errors and returned as for lost. The client must be locked.
+func (client *ApnsConn) resend(dropped []sentNotification) []lateResult {
+	for i, n := range dropped {
+		err := ErrClientClosed
+		if atomic.LoadInt32(&client.closed) == 0 {
+			err = client.connect()
+		}
+		if err == nil {
+			err = client.reader.sent.reserve(context.Background(), client.settleTime())
+		}
+		if err == nil {
+			client.conn.SetWriteDeadline(time.Now().Add(DefaultDialTimeout))
+			n.at = time.Now()
+			client.reader.sent.track(n)
+			client.reader.written = time.Now()
+			_, err = client.w.Write(n.packet)
+			if err == nil {
+				err = client.w.Flush()
+			}
+		}
+
+		if err != nil {
+			client.disconnect(err)
+			late := make([]lateResult, 0, len(dropped)-i)
+			for _, n := range dropped[i:] {
+				late = append(late, client.lost(n, err))
+			}
+			return late
+		}
+		client.queue.extend(n.id, client.settleTime())
+
+		atomic.AddUint64(&client.counters().retried, 1)
+		atomic.AddUint64(&client.counters().sent, 1)
+		atomic.AddUint64(&client.counters().bytes, uint64(len(n.packet)))
+		client.lastUsed = client.now()
+	}
+	return nil
+}
@@ -0,0 +1,294 @@
+package apns
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func Test_BackgroundReader(t *testing.T) {
+	// each connection rejects its second notification, once the third
+	// one was written
+	dials := 0
+	client := &ApnsConn{BackgroundReader: true, ReadTimeout: time.Hour, Transport: servedTransport(t, func(server net.Conn, _ int) {
+		dials++
+		b := make([]byte, 256)
+		var rejected uint32
+		for i := 1; i <= 3; i++ {
+			n, err := server.Read(b)
+			if err != nil {
+				return
+			}
+			if i == 2 {
+				rejected = binary.BigEndian.Uint32(b[n-11:])
+			}
+		}
+		resp := []byte{8, byte(StatusInvalidToken), 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(resp[2:], rejected)
+		server.Write(resp)
+		server.Close()
+	})}
+	errs := client.Errors()
+	invalid := make(chan string, 1)
+	client.OnTokenInvalid = func(token string, at time.Time) { invalid <- token }
+
+	start := time.Now()
+	var ids []uint32
+	for _, token := range []string{"0a", "0b", "0c"} {
+		resp, err := client.Send(&Notification{DeviceToken: token, Payload: []byte("{}")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, resp.Identifier)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("Sends waited for error responses: %v", time.Since(start))
+	}
+
+	select {
+	case e := <-errs:
+		if e.Identifier != ids[1] || e.Token != "0b" || e.Status != StatusInvalidToken {
+			t.Errorf("Unexpected error %+v", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Rejection not reported")
+	}
+	if token := <-invalid; token != "0b" {
+		t.Errorf("Token %s reported invalid", token)
+	}
+
+	// the reader closed the connection: the next send reconnects
+	connected := true
+	for i := 0; i < 100 && connected; i++ {
+		time.Sleep(time.Millisecond)
+		client.mu.Lock()
+		connected = client.connected
+		client.mu.Unlock()
+	}
+	if connected {
+		t.Error("Connection kept after the error response")
+	}
+	client.Send(&Notification{DeviceToken: "0d", Payload: []byte("{}")})
+	if dials != 2 {
+		t.Errorf("Expected a reconnection, got %d dials", dials)
+	}
+}
+
+func Test_Pipelined(t *testing.T) {
+	// the first connection rejects the second notification once five
+	// were written, the second one takes everything
+	resent := make(chan uint32, 10)
+	dials := 0
+	client := &ApnsConn{Pipelined: true, ReadTimeout: time.Second, Transport: servedTransport(t, func(server net.Conn, dial int) {
+		dials++
+		first := dial == 1
+		b := make([]byte, 256)
+		var rejected []byte
+		for i := 1; ; i++ {
+			n, err := server.Read(b)
+			if err != nil {
+				return
+			}
+			if !first {
+				resent <- binary.BigEndian.Uint32(b[n-11:])
+			} else if i == 2 {
+				rejected = append([]byte(nil), b[n-11:n-7]...)
+			} else if i == 5 {
+				server.Write(append([]byte{8, byte(StatusInvalidToken)}, rejected...))
+				server.Close()
+				return
+			}
+		}
+	})}
+	errs := client.Errors()
+
+	var ids []uint32
+	for i := 0; i < 5; i++ {
+		resp, err := client.Send(&Notification{DeviceToken: "0a0b", Payload: []byte("{}")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, resp.Identifier)
+	}
+
+	if e := <-errs; e.Identifier != ids[1] {
+		t.Errorf("Unexpected rejection %+v", e)
+	}
+	for _, id := range ids[2:] {
+		select {
+		case got := <-resent:
+			if got != id {
+				t.Errorf("Resent %d, expected %d", got, id)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Notification %d not resent", id)
+		}
+	}
+	if stats := client.Stats(); stats.Retried != 3 {
+		t.Errorf("Expected 3 resends, got %d", stats.Retried)
+	}
+}
+
+func Test_PipelineWindow(t *testing.T) {
+	client := &ApnsConn{Pipelined: true, PipelineWindow: 2, ReadTimeout: 50 * time.Millisecond, Transport: servedTransport(t, func(server net.Conn, _ int) {
+		io.Copy(io.Discard, server)
+	})}
+	n := &Notification{DeviceToken: "0a0b", Payload: []byte("{}")}
+
+	start := time.Now()
+	client.Send(n)
+	client.Send(n)
+	if time.Since(start) > 40*time.Millisecond {
+		t.Fatal("Sends blocked with room in the window")
+	}
+	client.Send(n)
+	if time.Since(start) < 50*time.Millisecond {
+		t.Error("Send did not wait for room in the window")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := client.SendContext(ctx, n); err != context.Canceled {
+		t.Errorf("Expected the cancelled context, got %v", err)
+	}
+}
+
+func Test_SentHistoryRing(t *testing.T) {
+	h := &sentHistory{size: 3}
+	for id := uint32(1); id <= 5; id++ {
+		h.track(sentNotification{id: id, at: time.Now().Add(-time.Hour)})
+	}
+	if _, ok := h.lookup(2); ok {
+		t.Fatal("Expected 2 to be evicted")
+	}
+	if n, ok := h.lookup(4); !ok || n.id != 4 {
+		t.Fatal("Expected to find 4, got", n, ok)
+	}
+
+	ids := func(notifications []sentNotification) (ids []uint32) {
+		for _, n := range notifications {
+			ids = append(ids, n.id)
+		}
+		return ids
+	}
+	if got := ids(h.after(3)); len(got) != 2 || got[0] != 4 || got[1] != 5 {
+		t.Fatal("Expected 4 and 5 after 3, got", got)
+	}
+	// the rejected notification was evicted: all are more recent
+	if got := ids(h.after(1)); len(got) != 3 || got[0] != 3 || got[2] != 5 {
+		t.Fatal("Expected 3 to 5 after 1, got", got)
+	}
+
+	h.untrack(5)
+	if got := ids(h.after(0)); len(got) != 2 || got[1] != 4 {
+		t.Fatal("Expected 5 to be untracked, got", got)
+	}
+
+	// settled entries make room at once
+	h.track(sentNotification{id: 6, at: time.Now().Add(-time.Hour)})
+	if err := h.reserve(context.Background(), time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(h.after(0)); len(got) != 2 || got[0] != 4 {
+		t.Fatal("Expected 3 to be dropped, got", got)
+	}
+
+	// recent ones block until the context is done
+	h.track(sentNotification{id: 7, at: time.Now()})
+	h.track(sentNotification{id: 8, at: time.Now()})
+	h.track(sentNotification{id: 9, at: time.Now()})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := h.reserve(ctx, time.Hour); err != context.DeadlineExceeded {
+		t.Fatal("Expected the reserve to wait, got", err)
+	}
+}
+
+func Test_BackgroundReaderClose(t *testing.T) {
+	// the gateway answers the only notification after a while, with a
+	// rejection or with a response carrying no error status
+	for _, status := range []Status{StatusInvalidToken, StatusNoErrors} {
+		client := &ApnsConn{BackgroundReader: true, ReadTimeout: time.Second, Transport: servedTransport(t, func(server net.Conn, _ int) {
+			b := make([]byte, 256)
+			n, err := server.Read(b)
+			if err != nil {
+				return
+			}
+			time.Sleep(50 * time.Millisecond)
+			server.Write(append([]byte{8, byte(status)}, b[n-11:n-7]...))
+			server.Close()
+		})}
+		errs := client.Errors()
+
+		resp, err := client.Send(&Notification{DeviceToken: "0a", Payload: []byte("{}")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := client.Close(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case e := <-errs:
+			if status == StatusNoErrors {
+				if !errors.Is(e, ErrUnexpectedResponse) {
+					t.Errorf("Unexpected error %v", e)
+				}
+			} else if e.Identifier != resp.Identifier || e.Status != status {
+				t.Errorf("Unexpected error %+v", e)
+			}
+			t.Log(e)
+		default:
+			t.Errorf("Response with status %d not reported before Close returned", status)
+		}
+	}
+}
+
+func Test_PipelinedStaleConnection(t *testing.T) {
+	// the gateway closes the connection after two notifications, without
+	// an error response
+	received := make(chan uint32, 4)
+	client := &ApnsConn{Pipelined: true, ReadTimeout: time.Second, Transport: servedTransport(t, func(server net.Conn, _ int) {
+		b := make([]byte, 256)
+		for {
+			n, err := server.Read(b)
+			if err != nil {
+				return
+			}
+			received <- binary.BigEndian.Uint32(b[n-11:])
+			if len(received) == 2 {
+				server.Close()
+				return
+			}
+		}
+	})}
+	errs := client.Errors()
+
+	var ids []uint32
+	for i := 0; i < 2; i++ {
+		resp, err := client.Send(&Notification{DeviceToken: "0a0b", Payload: []byte("{}")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, resp.Identifier)
+	}
+
+	// without RetryAmbiguous both are reported as possibly lost
+	for _, id := range ids {
+		select {
+		case e := <-errs:
+			if e.Identifier != id || e.Token != "0a0b" || !errors.Is(e, ErrStaleConnection) {
+				t.Errorf("Unexpected error %+v", e)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Notification %d not reported", id)
+		}
+	}
+	if stats := client.Stats(); stats.Retried != 0 {
+		t.Errorf("Expected no resend, got %d", stats.Retried)
+	}
+}
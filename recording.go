@@ -0,0 +1,172 @@
+package apns
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+)
+
+// Record kinds of a recording.
+const (
+	recordWrite  = 'w' // bytes written by the client
+	recordRead   = 'r' // bytes read from the gateway
+	recordClosed = 'c' // the gateway closed the connection
+)
+
+// RecordingTransport dials through Transport and records every byte
+// exchanged with the gateway to w, to reproduce a session offline with
+// NewReplayTransport. Records are a kind byte, the connection number and
+// the data length as big endian uint32, then the data.
+type RecordingTransport struct {
+	Transport Transport // DefaultTransport if nil
+
+	mu    sync.Mutex
+	w     io.Writer
+	conns uint32
+	err   error
+}
+
+func NewRecordingTransport(transport Transport, w io.Writer) *RecordingTransport {
+	return &RecordingTransport{Transport: transport, w: w}
+}
+
+func (t *RecordingTransport) Dial(endpoint string, config *tls.Config) (net.Conn, error) {
+	transport := t.Transport
+	if transport == nil {
+		transport = DefaultTransport
+	}
+	conn, err := transport.Dial(endpoint, config)
+	if err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	id := t.conns
+	t.conns++
+	t.mu.Unlock()
+	return &recordingConn{Conn: conn, t: t, id: id}, nil
+}
+
+// Err returns the first error writing the recording, which stops it.
+func (t *RecordingTransport) Err() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.err
+}
+
+func (t *RecordingTransport) record(kind byte, conn uint32, data []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.err != nil {
+		return
+	}
+
+	header := [9]byte{kind}
+	binary.BigEndian.PutUint32(header[1:], conn)
+	binary.BigEndian.PutUint32(header[5:], uint32(len(data)))
+	_, t.err = t.w.Write(append(header[:], data...))
+}
+
+type recordingConn struct {
+	net.Conn
+	t  *RecordingTransport
+	id uint32
+}
+
+func (c *recordingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.t.record(recordRead, c.id, b[:n])
+	}
+	if err == io.EOF {
+		c.t.record(recordClosed, c.id, nil)
+	}
+	return n, err
+}
+
+func (c *recordingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		c.t.record(recordWrite, c.id, b[:n])
+	}
+	return n, err
+}
+
+type record struct {
+	kind byte
+	data []byte
+}
+
+// NewReplayTransport reads a recording made with RecordingTransport and
+// returns a Transport playing the gateway side of it: the n-th connection
+// dialed waits for as many bytes as the client wrote on the n-th recorded
+// connection before sending what the gateway answered, and is closed
+// where the gateway closed it. The bytes written are not compared.
+func NewReplayTransport(r io.Reader) (Transport, error) {
+	var conns [][]record
+	for {
+		header := [9]byte{}
+		_, err := io.ReadFull(r, header[:])
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		kind := header[0]
+		if kind != recordWrite && kind != recordRead && kind != recordClosed {
+			return nil, errors.New("Invalid recording")
+		}
+		id := binary.BigEndian.Uint32(header[1:])
+		data := make([]byte, binary.BigEndian.Uint32(header[5:]))
+		_, err = io.ReadFull(r, data)
+		if err != nil {
+			return nil, err
+		}
+
+		for uint32(len(conns)) <= id {
+			conns = append(conns, nil)
+		}
+		conns[id] = append(conns[id], record{kind: kind, data: data})
+	}
+
+	var mu sync.Mutex
+	dialed := 0
+	return TransportFunc(func(endpoint string, config *tls.Config) (net.Conn, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if dialed >= len(conns) {
+			return nil, errors.New("No more recorded connections")
+		}
+		records := conns[dialed]
+		dialed++
+
+		server, conn := net.Pipe()
+		go replay(server, records)
+		return conn, nil
+	}), nil
+}
+
+func replay(server net.Conn, records []record) {
+	defer server.Close()
+	for _, rec := range records {
+		var err error
+		switch rec.kind {
+		case recordWrite:
+			_, err = io.ReadFull(server, make([]byte, len(rec.data)))
+		case recordRead:
+			_, err = server.Write(rec.data)
+		case recordClosed:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+	// the recording ends with the connection open
+	io.Copy(io.Discard, server)
+}
@@ -0,0 +1,59 @@
+package apns
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func Test_RecordAndReplay(t *testing.T) {
+	// the gateway rejects the second notification of each connection
+	gateway := servedTransport(t, func(server net.Conn, _ int) {
+		b := make([]byte, 256)
+		server.Read(b)
+		server.Read(b)
+		server.Write([]byte{8, byte(StatusInvalidToken), 0, 0, 0, 2})
+		server.Close()
+	})
+
+	session := func(transport Transport) []error {
+		client := &ApnsConn{ReadTimeout: 20 * time.Millisecond, Transport: transport}
+		var errs []error
+		for i := 1; i <= 3; i++ {
+			_, err := client.Send(&Notification{DeviceToken: "0a0b0c", Payload: []byte("{}"), Identifier: uint32(i)})
+			errs = append(errs, err)
+		}
+		client.Close(context.Background())
+		return errs
+	}
+
+	var recording bytes.Buffer
+	recorder := NewRecordingTransport(gateway, &recording)
+	recorded := session(recorder)
+	if recorder.Err() != nil {
+		t.Fatal(recorder.Err())
+	}
+
+	replayer, err := NewReplayTransport(bytes.NewReader(recording.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayed := session(replayer)
+
+	var statusErr *StatusError
+	if recorded[0] != nil || !errors.As(recorded[1], &statusErr) || recorded[2] != nil {
+		t.Fatalf("Unexpected recorded session %v", recorded)
+	}
+	for i := range recorded {
+		if (recorded[i] == nil) != (replayed[i] == nil) || (recorded[i] != nil && recorded[i].Error() != replayed[i].Error()) {
+			t.Errorf("Send %d replayed as %v, recorded %v", i+1, replayed[i], recorded[i])
+		}
+	}
+
+	if _, err = NewReplayTransport(bytes.NewReader([]byte{'x', 0, 0, 0, 0, 0, 0, 0, 0})); err == nil {
+		t.Error("Invalid recording accepted")
+	}
+}
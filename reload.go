@@ -0,0 +1,116 @@
+package apns
+
+import (
+	"context"
+	"crypto/tls"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// ReloadCertificate loads a new certificate and key pair from the given
+// files and switches the client to it, see SetCertificate.
+func (client *ApnsConn) ReloadCertificate(certificate, key string) error {
+	cert, err := tls.LoadX509KeyPair(certificate, key)
+	if err != nil {
+		return err
+	}
+	return client.SetCertificate(cert)
+}
+
+// SetCertificate replaces the client certificate, e.g. when rotating it
+// before it expires. The certificate is checked first; if it is accepted
+// the current connection is closed and the next send reconnects with it.
+// Notifications being sent complete on the old connection.
+func (client *ApnsConn) SetCertificate(cert tls.Certificate) error {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	err := checkCertificate(&cert, client.endpoint, client.now())
+	if err != nil {
+		return err
+	}
+
+	config := &tls.Config{}
+	if client.tls_cfg != nil {
+		config = client.tls_cfg.Clone()
+	}
+	config.Certificates = []tls.Certificate{cert}
+	client.tls_cfg = config
+
+	client.topicOnce = sync.Once{}
+	client.topic = ""
+
+	client.disconnect(ErrCertificateReplaced)
+	return nil
+}
+
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+func statFiles(paths ...string) ([]fileState, error) {
+	states := make([]fileState, len(paths))
+	for i, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		states[i] = fileState{info.ModTime(), info.Size()}
+	}
+	return states, nil
+}
+
+func sameFiles(a, b []fileState) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].modTime.Equal(b[i].modTime) || a[i].size != b[i].size {
+			return false
+		}
+	}
+	return true
+}
+
+// WatchCertificate checks the certificate and key files every interval
+// and reloads them when they change, for certificates rotated by an
+// external agent. A failed reload, e.g. when only one of the files was
+// replaced so far, is logged and tried again at the next check; the
+// client keeps its current certificate meanwhile. It blocks until ctx is
+// done, so run it in its own goroutine.
+func (client *ApnsConn) WatchCertificate(ctx context.Context, certificate, key string, interval time.Duration) error {
+	current, err := statFiles(certificate, key)
+	if err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		files, err := statFiles(certificate, key)
+		if err != nil {
+			log.Printf("Certificate watcher: cannot check the files: %v", err)
+			continue
+		}
+		if sameFiles(files, current) {
+			continue
+		}
+
+		err = client.ReloadCertificate(certificate, key)
+		if err != nil {
+			log.Printf("Certificate watcher: reload failed: %v", err)
+			continue
+		}
+		current = files
+	}
+}
@@ -0,0 +1,110 @@
+package apns
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_SetCertificate(t *testing.T) {
+	var configs []*tls.Config
+	client := &ApnsConn{ReadTimeout: 10 * time.Millisecond, Transport: TransportFunc(func(endpoint string, config *tls.Config) (net.Conn, error) {
+		configs = append(configs, config)
+		server, conn := net.Pipe()
+		go io.Copy(io.Discard, server)
+		return conn, nil
+	})}
+	n := &Notification{DeviceToken: "0a0b0c", Payload: []byte("{}")}
+
+	client.SetCertificate(*testCertificate(t, "Apple Push Services: old", time.Now().Add(-time.Hour), time.Now().Add(time.Hour)))
+	client.Send(n)
+
+	cert := testCertificate(t, "Apple Push Services: new", time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	err := client.SetCertificate(*cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Send(n)
+
+	if len(configs) != 2 {
+		t.Fatalf("Expected a reconnection, got %d connections", len(configs))
+	}
+	if string(configs[1].Certificates[0].Certificate[0]) != string(cert.Certificate[0]) {
+		t.Error("Reconnection did not use the new certificate")
+	}
+
+	expired := testCertificate(t, "Apple Push Services: expired", time.Now().Add(-2*time.Hour), time.Now().Add(-time.Hour))
+	err = client.SetCertificate(*expired)
+	expectAuthError(t, err, CertificateExpired)
+	if string(client.certificate().Certificate[0]) != string(cert.Certificate[0]) || len(configs) != 2 {
+		t.Error("Rejected certificate replaced the current one")
+	}
+}
+
+func writeCertificate(t *testing.T, dir string, cert *tls.Certificate) (string, string) {
+	t.Helper()
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0600)
+	if err == nil {
+		err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}), 0600)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func Test_WatchCertificate(t *testing.T) {
+	dir := t.TempDir()
+	valid := func() *tls.Certificate {
+		return testCertificate(t, "Apple Push Services: app", time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	}
+	certFile, keyFile := writeCertificate(t, dir, valid())
+
+	client := &ApnsConn{}
+	err := client.ReloadCertificate(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- client.WatchCertificate(ctx, certFile, keyFile, 5*time.Millisecond)
+	}()
+
+	rotated := valid()
+	// make sure the modification time moves on coarse filesystems
+	time.Sleep(10 * time.Millisecond)
+	writeCertificate(t, dir, rotated)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		client.mu.Lock()
+		current := client.certificate().Certificate[0]
+		client.mu.Unlock()
+		if string(current) == string(rotated.Certificate[0]) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Rotated certificate was not loaded")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	cancel()
+	if err = <-done; err != context.Canceled {
+		t.Errorf("Expected Canceled, got %v", err)
+	}
+}
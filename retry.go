@@ -0,0 +1,180 @@
+package apns
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy makes a client send again notifications that failed for
+// transient reasons.
+type RetryPolicy struct {
+	// MaxAttempts counts the first attempt, so at most 1 disables retries.
+	MaxAttempts int
+
+	// Backoff returns the delay before a retry, attempt is 1 for the
+	// first retry. ExponentialBackoff from 100ms to 5s if nil.
+	Backoff func(attempt int) time.Duration
+
+	// Retryable classifies failures, IsRetryable if nil.
+	Retryable func(resp *Response, err error) bool
+
+	// RetryAmbiguous also resends notifications that were written before
+	// the connection failed without an answer from Apple. They may have
+	// been delivered, and the binary gateway does not deduplicate
+	// identifiers, so the device can get them twice.
+	RetryAmbiguous bool
+
+	// DedupeWindow is how long the identifiers of accepted notifications
+	// are remembered, DEDUPE_WINDOW if 0. A retry of one of them, e.g.
+	// after a gateway shutdown naming it as the last accepted, returns
+	// at once as accepted instead of sending it again. Identifiers must
+	// not be reused within the window.
+	DedupeWindow time.Duration
+}
+
+// ExponentialBackoff waits base before the first retry and doubles the
+// delay for each further one, up to max.
+func ExponentialBackoff(base, max time.Duration) func(attempt int) time.Duration {
+	return func(attempt int) time.Duration {
+		delay := base
+		for i := 1; i < attempt && delay < max; i++ {
+			delay *= 2
+		}
+		if delay > max {
+			delay = max
+		}
+		return delay
+	}
+}
+
+var defaultBackoff = ExponentialBackoff(100*time.Millisecond, 5*time.Second)
+
+// IsRetryable reports whether a failed send may succeed if tried again:
+// the connection was reset, closed or timed out, or the gateway answered
+// with a retryable status. Invalid requests, certificate problems and
+// cancelled contexts are final.
+func IsRetryable(resp *Response, err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Status.IsRetryable()
+	}
+	var authErr *AuthError
+	if errors.As(err, &authErr) {
+		return false
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if isConnectionClosed(err) || errors.Is(err, ErrDroppedAfterRejection) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	return false
+}
+
+// isGatewayError tells whether err is an answer from Apple, as opposed to a
+// failure of the connection.
+func isGatewayError(err error) bool {
+	var statusErr *StatusError
+	var unknownErr *UnknownStatusError
+	return errors.As(err, &statusErr) || errors.As(err, &unknownErr) || errors.Is(err, ErrDroppedAfterRejection)
+}
+
+// isConnectionClosed tells whether err means the peer closed or reset the
+// connection.
+func isConnectionClosed(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrClosedPipe) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE)
+}
+
+// sendOnce is send, repeated once on a new connection when the one it
+// reused was stale. A notification written before the connection failed
+// is only sent again with RetryAmbiguous.
+func (client *ApnsConn) sendOnce(ctx context.Context, id uint32, readTimeout time.Duration, token, payload []byte, encode func(w packetWriter, transactionId uint32) error) (*Response, error) {
+	resp, err := client.send(ctx, id, readTimeout, token, payload, encode)
+	client.rememberAccepted(resp, err)
+	if !errors.Is(err, ErrStaleConnection) {
+		return resp, err
+	}
+	if resp != nil {
+		if client.Retry == nil || !client.Retry.RetryAmbiguous {
+			return resp, err
+		}
+		id = resp.Identifier
+		if client.wasAccepted(id) {
+			return &Response{Identifier: id}, nil
+		}
+	}
+	atomic.AddUint64(&client.counters().retried, 1)
+	resp, err = client.send(ctx, id, readTimeout, token, payload, encode)
+	client.rememberAccepted(resp, err)
+	return resp, err
+}
+
+// sendWithRetry is send, repeated as set by client.Retry. Notifications
+// that were written are resent with the same identifier, so an id of 0 is
+// only assigned once.
+func (client *ApnsConn) sendWithRetry(ctx context.Context, id uint32, readTimeout time.Duration, token, payload []byte, encode func(w packetWriter, transactionId uint32) error) (resp *Response, err error) {
+	start := time.Now()
+	defer func() {
+		client.counters().record(resp, err)
+		if client.OnSend != nil {
+			client.OnSend(resp, err, time.Since(start))
+		}
+	}()
+
+	policy := client.Retry
+	if policy == nil || policy.MaxAttempts <= 1 {
+		return client.sendOnce(ctx, id, readTimeout, token, payload, encode)
+	}
+
+	retryable := policy.Retryable
+	if retryable == nil {
+		retryable = IsRetryable
+	}
+	backoff := policy.Backoff
+	if backoff == nil {
+		backoff = defaultBackoff
+	}
+
+	for attempt := 1; ; attempt++ {
+		resp, err = client.sendOnce(ctx, id, readTimeout, token, payload, encode)
+		if err == nil || attempt >= policy.MaxAttempts || !retryable(resp, err) {
+			return resp, err
+		}
+		if resp != nil {
+			if !policy.RetryAmbiguous && !isGatewayError(err) {
+				// written, but the connection failed before Apple
+				// answered: it may have been delivered
+				return resp, err
+			}
+			// retries keep the identifier, so that error responses and
+			// logs refer to one notification
+			id = resp.Identifier
+			if client.wasAccepted(id) {
+				return &Response{Identifier: id}, nil
+			}
+		}
+
+		timer := time.NewTimer(backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return resp, err
+		case <-timer.C:
+		}
+		atomic.AddUint64(&client.counters().retried, 1)
+	}
+}
@@ -0,0 +1,216 @@
+package apns
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// retryTransport hands the server side of each new connection to the next
+// of answers once the first packet was read, recording its identifier.
+func retryTransport(ids *[]uint32, answers []func(server net.Conn)) Transport {
+	return TransportFunc(func(endpoint string, config *tls.Config) (net.Conn, error) {
+		server, conn := net.Pipe()
+		answer := answers[len(*ids)%len(answers)]
+		received := make(chan struct{})
+		go func() {
+			b := make([]byte, 256)
+			n, _ := server.Read(b)
+			// command 2 without priority: the id item precedes the expiration
+			*ids = append(*ids, binary.BigEndian.Uint32(b[n-11:]))
+			close(received)
+			answer(server)
+		}()
+		return &syncConn{Conn: conn, received: received}, nil
+	})
+}
+
+// syncConn waits for the server to record a packet before reading.
+type syncConn struct {
+	net.Conn
+	received chan struct{}
+}
+
+func (c *syncConn) Read(b []byte) (int, error) {
+	<-c.received
+	return c.Conn.Read(b)
+}
+
+func Test_RetryPolicy(t *testing.T) {
+	var ids []uint32
+	client := &ApnsConn{
+		ReadTimeout: 20 * time.Millisecond,
+		Retry: &RetryPolicy{MaxAttempts: 3, Backoff: func(int) time.Duration {
+			return 0
+		}},
+		Transport: retryTransport(&ids, []func(net.Conn){
+			func(server net.Conn) {
+				server.Write([]byte{8, byte(StatusProcessingError), 0, 0, 0, 1})
+				server.Close()
+			},
+			func(server net.Conn) {},
+		}),
+	}
+
+	resp, err := client.Send(&Notification{DeviceToken: "0a0b0c", Payload: []byte("{}")})
+	if err != nil || !resp.Accepted() {
+		t.Fatalf("Send was not retried: %v %v", resp, err)
+	}
+	if len(ids) != 2 || ids[0] != ids[1] || resp.Identifier != ids[0] {
+		t.Errorf("Retry changed the identifier: %v, response %d", ids, resp.Identifier)
+	}
+}
+
+func Test_RetryAmbiguous(t *testing.T) {
+	var ids []uint32
+	policy := &RetryPolicy{MaxAttempts: 2, Backoff: func(int) time.Duration {
+		return 0
+	}}
+	client := &ApnsConn{
+		ReadTimeout: 20 * time.Millisecond,
+		Retry:       policy,
+		Transport: retryTransport(&ids, []func(net.Conn){
+			func(server net.Conn) { server.Close() },
+		}),
+	}
+
+	n := &Notification{DeviceToken: "0a0b0c", Payload: []byte("{}")}
+	_, err := client.Send(n)
+	if err == nil || len(ids) != 1 {
+		t.Errorf("Ambiguous failure was retried: %v, %d attempts", err, len(ids))
+	}
+
+	ids = nil
+	policy.RetryAmbiguous = true
+	client.Send(n)
+	if len(ids) != 2 {
+		t.Errorf("Ambiguous failure was not retried, %d attempts", len(ids))
+	}
+}
+
+func Test_RetryDedupe(t *testing.T) {
+	// a shutdown names the last notification accepted: the one sent if it
+	// was accepted, an earlier one otherwise
+	for _, last := range []uint32{5, 4} {
+		var ids []uint32
+		client := &ApnsConn{
+			ReadTimeout: 20 * time.Millisecond,
+			Retry: &RetryPolicy{MaxAttempts: 3, Backoff: func(int) time.Duration {
+				return 0
+			}},
+			Transport: retryTransport(&ids, []func(net.Conn){
+				func(server net.Conn) {
+					server.Write([]byte{8, byte(StatusShutdown), 0, 0, 0, byte(last)})
+					server.Close()
+				},
+				func(server net.Conn) {},
+			}),
+		}
+
+		resp, err := client.Send(&Notification{DeviceToken: "0a0b0c", Payload: []byte("{}"), Identifier: 5})
+		if err != nil || !resp.Accepted() || resp.Identifier != 5 {
+			t.Errorf("Send after a shutdown naming %d failed: %v %v", last, resp, err)
+		}
+		if expected := int(6 - last); len(ids) != expected {
+			t.Errorf("Shutdown naming %d: expected %d attempts, got %v", last, expected, ids)
+		}
+	}
+}
+
+func Test_StaleConnection(t *testing.T) {
+	// the first connection takes one notification, then is dropped by the
+	// gateway as the second one arrives, after reading it or before
+	stale := func(readSecond bool) (*ApnsConn, chan uint32, *int) {
+		received := make(chan uint32, 3)
+		dials := 0
+		client := &ApnsConn{ReadTimeout: 20 * time.Millisecond, Transport: servedTransport(t, func(server net.Conn, dial int) {
+			dials++
+			stale := dial == 1
+			b := make([]byte, 256)
+			for {
+				n, err := server.Read(b)
+				if err != nil {
+					return
+				}
+				received <- binary.BigEndian.Uint32(b[n-11:])
+				if stale && (len(received) == 2 || !readSecond) {
+					server.Close()
+					return
+				}
+			}
+		})}
+		return client, received, &dials
+	}
+	n := &Notification{DeviceToken: "0a0b0c", Payload: []byte("{}")}
+
+	// written before the connection closed: it may have been delivered
+	client, received, dials := stale(true)
+	client.Send(n)
+	resp, err := client.Send(n)
+	if !errors.Is(err, ErrStaleConnection) || resp == nil {
+		t.Fatalf("Expected a stale connection error, got %v %v", resp, err)
+	}
+	if *dials != 1 || len(received) != 2 {
+		t.Errorf("Ambiguous send was retried: %d dials, %d received", *dials, len(received))
+	}
+
+	client, received, dials = stale(true)
+	client.Retry = &RetryPolicy{MaxAttempts: 1, RetryAmbiguous: true}
+	client.Send(n)
+	resp, err = client.Send(n)
+	if err != nil || !resp.Accepted() {
+		t.Fatalf("Send on a stale connection was not retried: %v %v", resp, err)
+	}
+	ids := []uint32{<-received, <-received, <-received}
+	if *dials != 2 || ids[1] != ids[2] {
+		t.Errorf("Expected one resend on a new connection: %d dials, ids %v", *dials, ids)
+	}
+
+	// the write failed: the notification never left
+	client, received, dials = stale(false)
+	client.Send(&Notification{DeviceToken: "0a0b0c", Payload: []byte("{}"), ReadTimeout: NO_READ_WAIT})
+	for i := 0; i < 100 && len(received) == 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(5 * time.Millisecond)
+	resp, err = client.Send(n)
+	if err != nil || !resp.Accepted() {
+		t.Fatalf("Failed write on a stale connection was not retried: %v %v", resp, err)
+	}
+	ids = []uint32{<-received, <-received}
+	if *dials != 2 || ids[0] == ids[1] {
+		t.Errorf("Expected one send on a new connection: %d dials, ids %v", *dials, ids)
+	}
+	if retried := client.Stats().Retried; retried != 1 {
+		t.Errorf("Expected the stale write to be retried, got %d retries", retried)
+	}
+}
+
+func Test_IsRetryable(t *testing.T) {
+	for _, c := range []struct {
+		err       error
+		retryable bool
+	}{
+		{&StatusError{Status: StatusShutdown}, true},
+		{&StatusError{Status: StatusInvalidToken}, false},
+		{io.EOF, true},
+		{&net.OpError{Op: "dial", Err: errors.New("connection refused")}, true},
+		{newAuthError(CertificateRejected, &net.OpError{Op: "remote error"}), false},
+		{context.DeadlineExceeded, false},
+		{&PayloadTooLargeError{}, false},
+	} {
+		if IsRetryable(nil, c.err) != c.retryable {
+			t.Errorf("IsRetryable(%v) != %v", c.err, c.retryable)
+		}
+	}
+
+	backoff := ExponentialBackoff(time.Second, 3*time.Second)
+	if backoff(1) != time.Second || backoff(2) != 2*time.Second || backoff(5) != 3*time.Second {
+		t.Error("Unexpected backoff delays")
+	}
+}
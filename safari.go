@@ -0,0 +1,32 @@
+package apns
+
+import "errors"
+
+// SAFARI_TOPIC_PREFIX starts the website push ID, the topic of Safari
+// website push certificates.
+const SAFARI_TOPIC_PREFIX = "web."
+
+// NewSafariPayload builds a Safari website push. title and body are
+// required, action labels the button and may be empty. urlArgs fill the
+// placeholders of the urlFormatString of the push package; Apple requires
+// the url-args array even when there are none.
+func NewSafariPayload(title, body, action string, urlArgs ...string) (*Payload, error) {
+	if title == "" || body == "" {
+		return nil, errors.New("Safari notifications need a title and a body")
+	}
+	if urlArgs == nil {
+		urlArgs = []string{}
+	}
+
+	p := NewPayload()
+	p.SetAlert(&Alert{Title: title, Body: body, Action: action})
+	p.aps.URLArgs = urlArgs
+	return p, nil
+}
+
+// NewSafariClient creates a client for Safari website pushes. certificate
+// must be a "Website Push ID" certificate, whose topic starts with
+// SAFARI_TOPIC_PREFIX.
+func NewSafariClient(endpoint, certificate, key string) (*ApnsConn, error) {
+	return newTopicClient(endpoint, certificate, key, SAFARI_TOPIC_PREFIX, "a website push certificate")
+}
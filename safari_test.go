@@ -0,0 +1,18 @@
+package apns
+
+import "testing"
+
+func Test_NewSafariPayload(t *testing.T) {
+	p, err := NewSafariPayload("Flight A998 Now Boarding", "Boarding has begun for Flight A998.", "View", "boarding", "A998")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectPayload(t, p, `{"aps":{"alert":{"title":"Flight A998 Now Boarding","body":"Boarding has begun for Flight A998.","action":"View"},"url-args":["boarding","A998"]}}`)
+
+	p, _ = NewSafariPayload("Title", "Body", "")
+	expectPayload(t, p, `{"aps":{"alert":{"title":"Title","body":"Body"},"url-args":[]}}`)
+
+	if _, err = NewSafariPayload("", "Body", "View"); err == nil {
+		t.Error("Safari payload without a title accepted")
+	}
+}
@@ -0,0 +1,27 @@
+//go:build sqlite
+
+// The fake driver only recognizes the statements of Store: this runs the
+// same checks against SQLite itself, with the pure Go modernc.org/sqlite
+// driver.
+//
+//	go test -tags sqlite ./sqlitestore
+
+package sqlitestore
+
+import (
+	"database/sql"
+	"testing"
+
+	_ "modernc.org/sqlite"
+)
+
+func Test_StoreSQLite(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	// every connection to :memory: is a new database
+	db.SetMaxOpenConns(1)
+	testStore(t, db)
+}
@@ -0,0 +1,83 @@
+// Package sqlitestore implements apns.TokenStore on top of a SQLite
+// database. It only depends on database/sql: open the *sql.DB with the
+// SQLite driver of your choice and pass it to New.
+//
+//	db, err := sql.Open("sqlite3", "tokens.db")
+//	store, err := sqlitestore.New(db)
+//	client.TokenStore = store
+//
+// Tokens stay invalid until the app registers them again, e.g. once
+// reinstalled: call Registered from the code receiving device tokens from
+// the app, so that sends to them resume.
+//
+//	err = store.Registered(token, time.Now())
+package sqlitestore
+
+import (
+	"database/sql"
+	"time"
+)
+
+const schema = `CREATE TABLE IF NOT EXISTS apns_invalid_tokens (
+	token          TEXT PRIMARY KEY,
+	invalidated_at INTEGER NOT NULL
+)`
+
+// Store is a TokenStore backed by the apns_invalid_tokens table.
+type Store struct {
+	db *sql.DB
+}
+
+// New creates the table if needed and returns the store.
+func New(db *sql.DB) (*Store, error) {
+	_, err := db.Exec(schema)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{db: db}, nil
+}
+
+// MarkInvalid records token, keeping the most recent invalidation time.
+func (store *Store) MarkInvalid(token string, at time.Time) error {
+	_, err := store.db.Exec(`INSERT INTO apns_invalid_tokens (token, invalidated_at) VALUES (?, ?)
+		ON CONFLICT(token) DO UPDATE SET invalidated_at = MAX(invalidated_at, excluded.invalidated_at)`,
+		token, at.Unix())
+	return err
+}
+
+// Registered forgets token if the app registered it again after it was
+// reported invalid, e.g. once reinstalled. The client never calls it:
+// call it whenever the app sends its device token.
+func (store *Store) Registered(token string, at time.Time) error {
+	_, err := store.db.Exec(`DELETE FROM apns_invalid_tokens WHERE token = ? AND invalidated_at < ?`, token, at.Unix())
+	return err
+}
+
+func (store *Store) IsValid(token string) (bool, error) {
+	var count int
+	err := store.db.QueryRow(`SELECT COUNT(*) FROM apns_invalid_tokens WHERE token = ?`, token).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count == 0, nil
+}
+
+// List returns the invalid tokens sorted.
+func (store *Store) List() ([]string, error) {
+	rows, err := store.db.Query(`SELECT token FROM apns_invalid_tokens ORDER BY token`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []string
+	for rows.Next() {
+		var token string
+		err = rows.Scan(&token)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
@@ -0,0 +1,178 @@
+package sqlitestore
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeDriver runs the statements of Store against a map, standing in for
+// a SQLite driver.
+type fakeDriver struct {
+	mu      sync.Mutex
+	invalid map[string]int64
+}
+
+func (d *fakeDriver) Open(name string) (driver.Conn, error) {
+	return &fakeConn{d}, nil
+}
+
+type fakeConn struct {
+	d *fakeDriver
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{d: c.d, query: strings.Join(strings.Fields(query), " ")}, nil
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("Transactions not supported")
+}
+
+type fakeStmt struct {
+	d     *fakeDriver
+	query string
+}
+
+func (s *fakeStmt) Close() error {
+	return nil
+}
+
+func (s *fakeStmt) NumInput() int {
+	return -1
+}
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	switch {
+	case strings.HasPrefix(s.query, "CREATE TABLE IF NOT EXISTS apns_invalid_tokens"):
+	case strings.HasPrefix(s.query, "INSERT INTO apns_invalid_tokens") && strings.Contains(s.query, "MAX(invalidated_at, excluded.invalidated_at)"):
+		token, at := args[0].(string), args[1].(int64)
+		if seen, found := s.d.invalid[token]; !found || at > seen {
+			s.d.invalid[token] = at
+		}
+	case strings.HasPrefix(s.query, "DELETE FROM apns_invalid_tokens WHERE token = ? AND invalidated_at < ?"):
+		token, at := args[0].(string), args[1].(int64)
+		if seen, found := s.d.invalid[token]; found && seen < at {
+			delete(s.d.invalid, token)
+		}
+	default:
+		return nil, errors.New("Unexpected statement: " + s.query)
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	switch s.query {
+	case "SELECT COUNT(*) FROM apns_invalid_tokens WHERE token = ?":
+		count := int64(0)
+		if _, found := s.d.invalid[args[0].(string)]; found {
+			count = 1
+		}
+		return &fakeRows{values: [][]driver.Value{{count}}}, nil
+	case "SELECT token FROM apns_invalid_tokens ORDER BY token":
+		tokens := make([]string, 0, len(s.d.invalid))
+		for token := range s.d.invalid {
+			tokens = append(tokens, token)
+		}
+		sort.Strings(tokens)
+		rows := &fakeRows{}
+		for _, token := range tokens {
+			rows.values = append(rows.values, []driver.Value{token})
+		}
+		return rows, nil
+	}
+	return nil, errors.New("Unexpected query: " + s.query)
+}
+
+type fakeRows struct {
+	values [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string {
+	return []string{"value"}
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+var fake = &fakeDriver{invalid: make(map[string]int64)}
+
+func init() {
+	sql.Register("sqlitestore-fake", fake)
+}
+
+func Test_Store(t *testing.T) {
+	db, err := sql.Open("sqlitestore-fake", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	testStore(t, db)
+}
+
+// testStore runs the Store checks against db, empty.
+func testStore(t *testing.T, db *sql.DB) {
+	store, err := New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if valid, err := store.IsValid("0a0b"); err != nil || !valid {
+		t.Errorf("Unknown token reported invalid: %v", err)
+	}
+
+	reported := time.Unix(1000, 0)
+	for _, token := range []string{"0a0b", "01"} {
+		if err := store.MarkInvalid(token, reported); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// an older feedback tuple does not move the invalidation back
+	store.MarkInvalid("0a0b", reported.Add(-time.Hour))
+	if valid, err := store.IsValid("0a0b"); err != nil || valid {
+		t.Errorf("Invalid token reported valid: %v", err)
+	}
+	if tokens, err := store.List(); err != nil || len(tokens) != 2 || tokens[0] != "01" || tokens[1] != "0a0b" {
+		t.Errorf("Unexpected token list %v %v", tokens, err)
+	}
+
+	// registered before Apple reported it: still invalid
+	store.Registered("0a0b", reported.Add(-time.Minute))
+	if valid, _ := store.IsValid("0a0b"); valid {
+		t.Error("Token valid again after an earlier registration")
+	}
+	// registered again afterwards, e.g. once the app was reinstalled
+	if err := store.Registered("0a0b", reported.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if valid, err := store.IsValid("0a0b"); err != nil || !valid {
+		t.Errorf("Token registered again still invalid: %v", err)
+	}
+	if tokens, _ := store.List(); len(tokens) != 1 || tokens[0] != "01" {
+		t.Errorf("Unexpected token list %v", tokens)
+	}
+}
@@ -0,0 +1,78 @@
+package apns
+
+import "sync/atomic"
+
+// Stats are the delivery counters of a client since it was created or
+// since ResetStats.
+type Stats struct {
+	Sent         uint64            // notifications written, retries included
+	Accepted     uint64            // sends that returned without error
+	Failed       uint64            // sends that returned an error, rejections included
+	Rejected     map[Status]uint64 // error responses, by status
+	Retried      uint64            // notifications written again after a failure
+	Reconnects   uint64            // connections replacing a previous one
+	BytesWritten uint64
+	QueueDepth   int // notifications waiting for SendAsync
+}
+
+// clientStats holds the counters of Stats, accessed atomically.
+type clientStats struct {
+	sent, accepted, failed, retried, reconnects, bytes uint64
+	rejected                                           [256]uint64
+}
+
+func (s *clientStats) record(resp *Response, err error) {
+	if err == nil {
+		atomic.AddUint64(&s.accepted, 1)
+	} else {
+		atomic.AddUint64(&s.failed, 1)
+	}
+	if resp != nil && resp.Status != StatusNoErrors {
+		atomic.AddUint64(&s.rejected[resp.Status], 1)
+	}
+}
+
+// counters are the stats sends add to: a Fanout clone counts for the
+// client it was cloned from.
+func (client *ApnsConn) counters() *clientStats {
+	if client.parent != nil {
+		return client.parent.counters()
+	}
+	return &client.stats
+}
+
+// Stats returns a snapshot of the delivery counters, for status pages and
+// metrics exporters.
+func (client *ApnsConn) Stats() Stats {
+	s := client.counters()
+	stats := Stats{
+		Sent:         atomic.LoadUint64(&s.sent),
+		Accepted:     atomic.LoadUint64(&s.accepted),
+		Failed:       atomic.LoadUint64(&s.failed),
+		Rejected:     make(map[Status]uint64),
+		Retried:      atomic.LoadUint64(&s.retried),
+		Reconnects:   atomic.LoadUint64(&s.reconnects),
+		BytesWritten: atomic.LoadUint64(&s.bytes),
+	}
+	for status := range s.rejected {
+		if count := atomic.LoadUint64(&s.rejected[status]); count > 0 {
+			stats.Rejected[Status(status)] = count
+		}
+	}
+
+	client.queue.mu.Lock()
+	stats.QueueDepth = client.queue.queued
+	client.queue.mu.Unlock()
+	return stats
+}
+
+// ResetStats sets the counters back to zero, the queue depth is kept.
+func (client *ApnsConn) ResetStats() {
+	s := client.counters()
+	for _, counter := range []*uint64{&s.sent, &s.accepted, &s.failed, &s.retried, &s.reconnects, &s.bytes} {
+		atomic.StoreUint64(counter, 0)
+	}
+	for status := range s.rejected {
+		atomic.StoreUint64(&s.rejected[status], 0)
+	}
+}
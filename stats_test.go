@@ -0,0 +1,44 @@
+package apns
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func Test_Stats(t *testing.T) {
+	// every connection rejects its second notification
+	client := &ApnsConn{ReadTimeout: 10 * time.Millisecond, Transport: servedTransport(t, func(server net.Conn, _ int) {
+		b := make([]byte, 256)
+		for i := 1; ; i++ {
+			_, err := server.Read(b)
+			if err != nil {
+				return
+			}
+			if i == 2 {
+				server.Write([]byte{8, byte(StatusInvalidToken), 0, 0, 0, 2})
+				server.Close()
+				return
+			}
+		}
+	})}
+
+	n := &Notification{DeviceToken: "0a0b0c", Payload: []byte("{}")}
+	for i := 0; i < 3; i++ {
+		client.Send(n)
+	}
+
+	stats := client.Stats()
+	if stats.Sent != 3 || stats.Accepted != 2 || stats.Failed != 1 || stats.Reconnects != 1 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+	if stats.Rejected[StatusInvalidToken] != 1 || len(stats.Rejected) != 1 || stats.BytesWritten == 0 {
+		t.Errorf("Unexpected rejections %v or bytes %d", stats.Rejected, stats.BytesWritten)
+	}
+
+	client.ResetStats()
+	stats = client.Stats()
+	if stats.Sent != 0 || stats.Failed != 0 || len(stats.Rejected) != 0 {
+		t.Errorf("Stats not reset: %+v", stats)
+	}
+}
@@ -0,0 +1,106 @@
+// Package statsd emits client metrics over the StatsD UDP protocol, with
+// Datadog style tags when given.
+//
+//	emitter, err := statsd.Dial("127.0.0.1:8125", "apns", "env:prod")
+//	emitter.Instrument(client)
+//
+// Every send counts as prefix.sent, then prefix.accepted or prefix.failed,
+// rejections also as prefix.rejected.<status code>, and is timed as
+// prefix.send_time. Reconnections count as prefix.reconnects.
+package statsd
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/Mistobaan/go-apns"
+)
+
+// Emitter writes metrics to a StatsD server. It is safe for concurrent
+// use; write errors are ignored, like UDP losses.
+type Emitter struct {
+	conn   net.Conn
+	prefix string
+	tags   string
+}
+
+// Dial creates an Emitter sending to addr. Metric names start with prefix
+// and a dot unless it is empty, tags ("key:value") are added to every
+// metric.
+func Dial(addr, prefix string, tags ...string) (*Emitter, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return New(conn, prefix, tags...), nil
+}
+
+// New creates an Emitter writing to conn.
+func New(conn net.Conn, prefix string, tags ...string) *Emitter {
+	e := &Emitter{conn: conn}
+	if prefix != "" {
+		e.prefix = prefix + "."
+	}
+	if len(tags) > 0 {
+		e.tags = "|#" + strings.Join(tags, ",")
+	}
+	return e
+}
+
+// Count adds value to the counter name.
+func (e *Emitter) Count(name string, value int64) {
+	e.write(name, fmt.Sprintf("%d|c", value))
+}
+
+// Gauge sets the gauge name.
+func (e *Emitter) Gauge(name string, value int64) {
+	e.write(name, fmt.Sprintf("%d|g", value))
+}
+
+// Timing records d, in milliseconds, for name.
+func (e *Emitter) Timing(name string, d time.Duration) {
+	e.write(name, fmt.Sprintf("%g|ms", float64(d)/float64(time.Millisecond)))
+}
+
+func (e *Emitter) write(name, value string) {
+	e.conn.Write([]byte(e.prefix + name + ":" + value + e.tags))
+}
+
+// Instrument makes client report its sends and reconnections, keeping the
+// OnSend and OnReconnect hooks it already has. Set it up before sending.
+func (e *Emitter) Instrument(client *apns.ApnsConn) {
+	onSend := client.OnSend
+	client.OnSend = func(resp *apns.Response, err error, elapsed time.Duration) {
+		if resp != nil {
+			e.Count("sent", 1)
+		}
+		if err == nil {
+			e.Count("accepted", 1)
+		} else {
+			e.Count("failed", 1)
+		}
+		if resp != nil && !resp.Accepted() {
+			e.Count(fmt.Sprintf("rejected.%d", resp.Status), 1)
+		}
+		e.Timing("send_time", elapsed)
+
+		if onSend != nil {
+			onSend(resp, err, elapsed)
+		}
+	}
+
+	onReconnect := client.OnReconnect
+	client.OnReconnect = func(reason error, attempt int) {
+		e.Count("reconnects", 1)
+		if onReconnect != nil {
+			onReconnect(reason, attempt)
+		}
+	}
+}
+
+// Close closes the connection to the server.
+func (e *Emitter) Close() error {
+	return e.conn.Close()
+}
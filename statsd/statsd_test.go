@@ -0,0 +1,52 @@
+package statsd
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Mistobaan/go-apns"
+	"github.com/Mistobaan/go-apns/apnstest"
+)
+
+func Test_Instrument(t *testing.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer listener.Close()
+
+	emitter, err := Dial(listener.LocalAddr().String(), "apns", "env:test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer emitter.Close()
+
+	server := apnstest.NewServer()
+	client := &apns.ApnsConn{Transport: server.Transport(), ReadTimeout: 10 * time.Millisecond}
+	emitter.Instrument(client)
+
+	_, err = client.Send(&apns.Notification{DeviceToken: apnstest.Token, Payload: []byte("{}")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var metrics []string
+	b := make([]byte, 512)
+	listener.SetReadDeadline(time.Now().Add(time.Second))
+	for len(metrics) < 3 {
+		n, _, err := listener.ReadFrom(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		metrics = append(metrics, string(b[:n]))
+	}
+
+	if metrics[0] != "apns.sent:1|c|#env:test" || metrics[1] != "apns.accepted:1|c|#env:test" {
+		t.Errorf("Unexpected counters %q", metrics)
+	}
+	if !strings.HasPrefix(metrics[2], "apns.send_time:") || !strings.HasSuffix(metrics[2], "|ms|#env:test") {
+		t.Errorf("Unexpected timing %q", metrics[2])
+	}
+}
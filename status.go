@@ -0,0 +1,117 @@
+package apns
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+)
+
+// Status is the status code carried by an error-response packet.
+// The constants below are the single source of truth for the status
+// texts and for classifying failures.
+type Status uint8
+
+const (
+	StatusNoErrors           Status = 0
+	StatusProcessingError    Status = 1
+	StatusMissingDeviceToken Status = 2
+	StatusMissingTopic       Status = 3
+	StatusMissingPayload     Status = 4
+	StatusInvalidTokenSize   Status = 5
+	StatusInvalidTopicSize   Status = 6
+	StatusInvalidPayloadSize Status = 7
+	StatusInvalidToken       Status = 8
+	StatusShutdown           Status = 10 // the gateway is going down for maintenance
+	StatusProtocolError      Status = 128
+	StatusUnknown            Status = 255
+)
+
+var errText = map[Status]string{
+	StatusNoErrors:           "No errors encountered",
+	StatusProcessingError:    "Processing Errors",
+	StatusMissingDeviceToken: "Missing Device Token",
+	StatusMissingTopic:       "Missing Topic",
+	StatusMissingPayload:     "Missing Payload",
+	StatusInvalidTokenSize:   "Invalid Token Size",
+	StatusInvalidTopicSize:   "Invalid Topic Size",
+	StatusInvalidPayloadSize: "Invalid Payload Size",
+	StatusInvalidToken:       "Invalid Token",
+	StatusShutdown:           "Shutdown",
+	StatusProtocolError:      "Protocol Error",
+	StatusUnknown:            "None (Unknown)",
+}
+
+func (s Status) String() string {
+	if text, ok := errText[s]; ok {
+		return text
+	}
+	return "Unknown status"
+}
+
+// IsTokenInvalid reports whether the device token should not be used again.
+func (s Status) IsTokenInvalid() bool {
+	return s == StatusInvalidToken
+}
+
+// IsKnown reports whether s is a status documented by Apple. Apple has
+// never assigned 9; it is treated like any other unknown code.
+func (s Status) IsKnown() bool {
+	_, known := errText[s]
+	return known
+}
+
+// IsRetryable reports whether sending the same notification again may
+// succeed: the failure was on Apple's side rather than in the request.
+// With StatusShutdown the reported identifier is the last notification
+// Apple accepted before closing the connection.
+func (s Status) IsRetryable() bool {
+	return s == StatusProcessingError || s == StatusShutdown || s == StatusUnknown
+}
+
+// StatusError is returned when the gateway rejects a notification.
+type StatusError struct {
+	Status     Status
+	Identifier uint32 // identifier of the notification, 0 if the response was truncated
+}
+
+func (e *StatusError) Error() string {
+	return e.Status.String()
+}
+
+// UnknownStatusError is returned for an error response whose status is
+// not documented, so that new codes are reported instead of misread.
+type UnknownStatusError struct {
+	Status     Status
+	Identifier uint32 // identifier of the notification, 0 if the response was truncated
+}
+
+func (e *UnknownStatusError) Error() string {
+	return fmt.Sprintf("Unknown error status %d for notification %d", uint8(e.Status), e.Identifier)
+}
+
+// ERROR_RESPONSE_COMMAND is the command byte of error responses.
+const ERROR_RESPONSE_COMMAND = 8
+
+// MalformedResponseError is returned when the gateway answers with bytes
+// that are not a 6 byte error response: a short read or garbage. Whether
+// the notification was delivered is unknown.
+type MalformedResponseError struct {
+	Data   []byte // what was read
+	Reason string
+}
+
+func (e *MalformedResponseError) Error() string {
+	return fmt.Sprintf("Malformed error response %s: %s", hex.EncodeToString(e.Data), e.Reason)
+}
+
+// parseErrorResponse decodes an error response: command 8, status and
+// the identifier of the failed notification.
+func parseErrorResponse(b []byte) (Status, uint32, error) {
+	if len(b) != 6 {
+		return 0, 0, &MalformedResponseError{Data: append([]byte(nil), b...), Reason: fmt.Sprintf("%d bytes instead of 6", len(b))}
+	}
+	if b[0] != ERROR_RESPONSE_COMMAND {
+		return 0, 0, &MalformedResponseError{Data: append([]byte(nil), b...), Reason: fmt.Sprintf("unknown command %d", b[0])}
+	}
+	return Status(b[1]), binary.BigEndian.Uint32(b[2:]), nil
+}
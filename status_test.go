@@ -0,0 +1,82 @@
+package apns
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func Test_Status(t *testing.T) {
+	if StatusShutdown.String() != "Shutdown" || !StatusShutdown.IsRetryable() {
+		t.Error("Shutdown should be a known, retryable status")
+	}
+	if Status(9).IsKnown() || Status(9).String() != "Unknown status" {
+		t.Error("Status 9 is not assigned by Apple")
+	}
+	if StatusInvalidToken.IsRetryable() || !StatusInvalidToken.IsTokenInvalid() {
+		t.Error("Invalid Token misclassified")
+	}
+
+	err := &UnknownStatusError{Status: 42, Identifier: 7}
+	if err.Error() != "Unknown error status 42 for notification 7" {
+		t.Errorf("Unexpected message %q", err.Error())
+	}
+}
+
+func Test_parseErrorResponse(t *testing.T) {
+	status, id, err := parseErrorResponse([]byte{8, 8, 0, 0, 1, 2})
+	if err != nil || status != StatusInvalidToken || id != 258 {
+		t.Errorf("Unexpected parse %d %d %v", status, id, err)
+	}
+
+	var malformed *MalformedResponseError
+	for _, b := range [][]byte{{}, {8, 8}, {1, 8, 0, 0, 0, 1}, {8, 8, 0, 0, 0, 1, 0}} {
+		_, _, err = parseErrorResponse(b)
+		if !errors.As(err, &malformed) {
+			t.Errorf("Malformed response %x accepted: %v", b, err)
+		}
+	}
+}
+
+func Test_ErrorResponseReads(t *testing.T) {
+	answer := func(chunks ...[]byte) *ApnsConn {
+		return &ApnsConn{ReadTimeout: time.Second, Transport: servedTransport(t, func(server net.Conn, _ int) {
+			server.Read(make([]byte, 256))
+			for _, chunk := range chunks {
+				server.Write(chunk)
+			}
+			server.Close()
+		})}
+	}
+	n := &Notification{DeviceToken: "0a0b0c", Payload: []byte("{}"), Identifier: 9}
+
+	resp, err := answer([]byte{8, 8, 0}, []byte{0, 0, 9}).Send(n)
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || resp.FailedIdentifier != 9 {
+		t.Errorf("Split error response misread: %v %+v", err, resp)
+	}
+
+	_, err = answer([]byte{8, 8}).Send(n)
+	var malformed *MalformedResponseError
+	if !errors.As(err, &malformed) {
+		t.Errorf("Short error response not reported: %v", err)
+	}
+}
+
+func FuzzParseErrorResponse(f *testing.F) {
+	f.Add([]byte{8, 8, 0, 0, 0, 1})
+	f.Add([]byte{8, 255, 255, 255, 255, 255})
+	f.Add([]byte{8})
+	f.Add([]byte{})
+	f.Fuzz(func(t *testing.T, b []byte) {
+		status, id, err := parseErrorResponse(b)
+		if err != nil {
+			return
+		}
+		if len(b) != 6 || b[0] != ERROR_RESPONSE_COMMAND || status != Status(b[1]) || id != binary.BigEndian.Uint32(b[2:]) {
+			t.Errorf("Misparsed %x as %d %d", b, status, id)
+		}
+	})
+}
@@ -0,0 +1,72 @@
+package apns
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrTokenInvalid is returned by the send methods when the TokenStore
+// already knows the device token to be invalid.
+var ErrTokenInvalid = errors.New("Device token was reported invalid")
+
+// TokenStore keeps track of device tokens Apple reported as invalid,
+// either through the feedback service or an Invalid Token error response.
+// Tokens are the hex strings used by SendPayloadString.
+type TokenStore interface {
+	MarkInvalid(token string, at time.Time) error
+	IsValid(token string) (bool, error)
+	List() ([]string, error)
+}
+
+// MemoryTokenStore is a TokenStore kept in memory.
+type MemoryTokenStore struct {
+	mu      sync.RWMutex
+	invalid map[string]time.Time
+}
+
+func NewMemoryTokenStore() *MemoryTokenStore {
+	return &MemoryTokenStore{invalid: make(map[string]time.Time)}
+}
+
+func (store *MemoryTokenStore) MarkInvalid(token string, at time.Time) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.invalid[token] = at
+	return nil
+}
+
+func (store *MemoryTokenStore) IsValid(token string) (bool, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	_, found := store.invalid[token]
+	return !found, nil
+}
+
+// List returns the invalid tokens sorted.
+func (store *MemoryTokenStore) List() ([]string, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	tokens := make([]string, 0, len(store.invalid))
+	for token := range store.invalid {
+		tokens = append(tokens, token)
+	}
+	sort.Strings(tokens)
+	return tokens, nil
+}
+
+// tokenInvalid records a token Apple reported invalid, at the time Apple
+// gave or when the error response arrived, and notifies OnTokenInvalid.
+func (client *ApnsConn) tokenInvalid(token string, at time.Time) {
+	if client.TokenStore != nil {
+		err := client.TokenStore.MarkInvalid(token, at)
+		if err != nil {
+			log.Printf("Could not record invalid token %s: %v", token, err)
+		}
+	}
+	if client.OnTokenInvalid != nil {
+		client.OnTokenInvalid(token, at)
+	}
+}
@@ -0,0 +1,70 @@
+package apns
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_MemoryTokenStore(t *testing.T) {
+	store := NewMemoryTokenStore()
+
+	valid, _ := store.IsValid("0a0b0c")
+	if !valid {
+		t.Error("Unknown token reported invalid")
+	}
+
+	store.MarkInvalid("0a0b0c", time.Now())
+	store.MarkInvalid("01", time.Now())
+
+	valid, _ = store.IsValid("0a0b0c")
+	if valid {
+		t.Error("Invalid token reported valid")
+	}
+
+	tokens, _ := store.List()
+	if len(tokens) != 2 || tokens[0] != "01" || tokens[1] != "0a0b0c" {
+		t.Errorf("Unexpected token list %v", tokens)
+	}
+}
+
+func Test_SendPayloadSkipsInvalidTokens(t *testing.T) {
+	client := &ApnsConn{TokenStore: NewMemoryTokenStore()}
+	client.TokenStore.MarkInvalid("0a0b0c", time.Now())
+
+	err := client.SendPayloadString("0a0b0c", []byte("{}"), time.Hour)
+	if err != ErrTokenInvalid {
+		t.Errorf("Expected ErrTokenInvalid, got %v", err)
+	}
+}
+
+func Test_OnTokenInvalid(t *testing.T) {
+	server, transport := pipeConn(t)
+	go func() {
+		buf := make([]byte, 1024)
+		server.Read(buf)
+		// error response: command 8, status 8 (Invalid Token), identifier
+		server.Write([]byte{8, 8, 0, 0, 0, 1})
+		server.Close()
+	}()
+
+	var reported string
+	client := &ApnsConn{
+		ReadTimeout: time.Second,
+		TokenStore:  NewMemoryTokenStore(),
+		Transport:   transport,
+		OnTokenInvalid: func(token string, at time.Time) {
+			reported = token
+		},
+	}
+
+	err := client.SendPayloadString("0a0b0c", []byte("{}"), time.Hour)
+	if err == nil {
+		t.Error("Rejected notification reported as sent")
+	}
+	if reported != "0a0b0c" {
+		t.Errorf("OnTokenInvalid not called, got %q", reported)
+	}
+	if valid, _ := client.TokenStore.IsValid("0a0b0c"); valid {
+		t.Error("Rejected token not recorded in the TokenStore")
+	}
+}
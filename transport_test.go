@@ -0,0 +1,115 @@
+package apns
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_TLSTransportTimeout(t *testing.T) {
+	transport := &TLSTransport{
+		Timeout: 10 * time.Millisecond,
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			// a blackholed gateway: the handshake never gets an answer
+			_, conn := net.Pipe()
+			return conn, nil
+		},
+	}
+
+	start := time.Now()
+	_, err := transport.Dial("gateway.push.apple.com:2195", &tls.Config{})
+	if err == nil {
+		t.Fatal("Handshake with a silent peer succeeded")
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("Dial did not honour the timeout: %v", time.Since(start))
+	}
+}
+
+func Test_TLSTransportLocalAddr(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer listener.Close()
+	// 127.0.0.1 is the default source address, dial from another one
+	probe, err := net.Listen("tcp", "127.0.0.2:0")
+	if err != nil {
+		t.Skip("127.0.0.2 not available: ", err)
+	}
+	probe.Close()
+
+	remote := make(chan net.Addr, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		remote <- conn.RemoteAddr()
+		conn.Close()
+	}()
+
+	transport := &TLSTransport{Timeout: time.Second, LocalAddr: &net.TCPAddr{IP: net.ParseIP("127.0.0.2")}}
+	_, err = transport.Dial(listener.Addr().String(), &tls.Config{})
+	if err == nil {
+		t.Fatal("Handshake with a plain TCP peer succeeded")
+	}
+
+	addr := <-remote
+	if ip := addr.(*net.TCPAddr).IP.String(); ip != "127.0.0.2" {
+		t.Errorf("Dialed from %s", ip)
+	}
+}
+
+// servedTransport connects every dial to a net.Pipe whose gateway side
+// is handed to serve on its own goroutine, with the number of the dial
+// from 1, and closed at the end of the test.
+func servedTransport(t *testing.T, serve func(server net.Conn, dial int)) Transport {
+	var mu sync.Mutex
+	dials := 0
+	return TransportFunc(func(endpoint string, config *tls.Config) (net.Conn, error) {
+		mu.Lock()
+		dials++
+		dial := dials
+		mu.Unlock()
+
+		server, conn := net.Pipe()
+		t.Cleanup(func() { server.Close() })
+		go serve(server, dial)
+		return conn, nil
+	})
+}
+
+// pipeTransport returns a Transport whose connections are pipes, and a
+// channel receiving what is written to them, one read at a time. Reads
+// are dropped while the channel is full.
+func pipeTransport(t *testing.T) (Transport, <-chan []byte) {
+	received := make(chan []byte, 16)
+	transport := servedTransport(t, func(server net.Conn, _ int) {
+		for {
+			b := make([]byte, 256)
+			n, err := server.Read(b)
+			if err != nil {
+				return
+			}
+			select {
+			case received <- b[:n]:
+			default:
+			}
+		}
+	})
+	return transport, received
+}
+
+// pipeConn returns the gateway side of a net.Pipe, closed at the end of
+// the test, and a Transport handing out the other side on every dial.
+func pipeConn(t *testing.T) (net.Conn, Transport) {
+	server, conn := net.Pipe()
+	t.Cleanup(func() { server.Close() })
+	return server, TransportFunc(func(endpoint string, config *tls.Config) (net.Conn, error) {
+		return conn, nil
+	})
+}
@@ -0,0 +1,91 @@
+package apns
+
+import (
+	"encoding/json"
+	"unicode/utf8"
+)
+
+// ELLIPSIS ends an alert body shortened by EncodeTruncated.
+const ELLIPSIS = "…"
+
+func (p *Payload) alertBody() string {
+	switch alert := p.aps.Alert.(type) {
+	case string:
+		return alert
+	case *Alert:
+		return alert.Body
+	}
+	return ""
+}
+
+func (p *Payload) setAlertBody(body string) {
+	switch alert := p.aps.Alert.(type) {
+	case string:
+		p.aps.Alert = body
+	case *Alert:
+		alert.Body = body
+	}
+}
+
+// truncateUTF8 returns the longest prefix of s of at most n bytes that
+// does not split a character.
+func truncateUTF8(s string, n int) string {
+	if n >= len(s) {
+		return s
+	}
+	if n <= 0 {
+		return ""
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// encodeTruncated marshals m, shortening the alert body of p, which m
+// encodes, until the result fits in maxSize bytes. The body is restored
+// before returning.
+func encodeTruncated(m json.Marshaler, p *Payload, maxSize int) ([]byte, error) {
+	original := p.alertBody()
+	defer p.setAlertBody(original)
+
+	body := original
+	for {
+		b, err := m.MarshalJSON()
+		if err != nil || len(b) <= maxSize {
+			return b, err
+		}
+		if body == "" {
+			return nil, &PayloadTooLargeError{Size: len(b), Limit: maxSize}
+		}
+
+		// escaping may make the body longer in JSON than in Go: cut by the
+		// excess and try again until it fits
+		cut := len(body) - (len(b) - maxSize) - len(ELLIPSIS)
+		if cut >= len(body) {
+			cut = len(body) - 1
+		}
+		body = truncateUTF8(body, cut)
+
+		if body == "" {
+			p.setAlertBody("")
+		} else {
+			p.setAlertBody(body + ELLIPSIS)
+		}
+	}
+}
+
+// EncodeTruncated is Encode, except that a payload larger than maxSize has
+// its alert body shortened, ending with ELLIPSIS, until it fits. Custom
+// keys and the rest of aps are never touched; if the payload does not fit
+// even with an empty body a PayloadTooLargeError is returned. p is left
+// unchanged.
+func (p *Payload) EncodeTruncated(maxSize int) ([]byte, error) {
+	return encodeTruncated(p, p, maxSize)
+}
+
+// EncodeTruncated is Encode with alert body truncation, see
+// Payload.EncodeTruncated.
+func (p *PayloadWithData[T]) EncodeTruncated(maxSize int) ([]byte, error) {
+	return encodeTruncated(p, &p.Payload, maxSize)
+}
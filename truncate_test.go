@@ -0,0 +1,76 @@
+package apns
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func Test_EncodeTruncated(t *testing.T) {
+	p := &PayloadWithData[map[string]string]{Data: map[string]string{"id": "42"}}
+	p.SetAlert(&Alert{Title: "News", Body: strings.Repeat("word ", 100)})
+
+	b, err := p.EncodeTruncated(128)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) > 128 {
+		t.Errorf("Truncated payload is %d bytes", len(b))
+	}
+
+	var doc struct {
+		Aps struct {
+			Alert Alert `json:"alert"`
+		} `json:"aps"`
+		ID string `json:"id"`
+	}
+	err = json.Unmarshal(b, &doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.ID != "42" || doc.Aps.Alert.Title != "News" {
+		t.Errorf("Truncation touched other keys: %s", b)
+	}
+	if !strings.HasSuffix(doc.Aps.Alert.Body, ELLIPSIS) || !strings.HasPrefix(doc.Aps.Alert.Body, "word word") {
+		t.Errorf("Unexpected truncated body %q", doc.Aps.Alert.Body)
+	}
+	if p.Alert().Body != strings.Repeat("word ", 100) {
+		t.Error("EncodeTruncated modified the payload")
+	}
+
+	small := NewPayload()
+	small.SetAlertText("short")
+	b, err = small.EncodeTruncated(128)
+	if err != nil || string(b) != `{"aps":{"alert":"short"}}` {
+		t.Errorf("Payload that fits was changed: %s %v", b, err)
+	}
+
+	if _, err = small.EncodeTruncated(10); err == nil {
+		t.Error("Payload that cannot fit was accepted")
+	}
+}
+
+func Test_EncodeTruncatedMultibyte(t *testing.T) {
+	p := NewPayload()
+	p.SetAlertText(strings.Repeat("🎉日本", 50))
+
+	for size := 40; size < 120; size++ {
+		b, err := p.EncodeTruncated(size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(b) > size || !utf8.Valid(b) || strings.ContainsRune(string(b), utf8.RuneError) {
+			t.Fatalf("Broken truncation to %d bytes: %q", size, b)
+		}
+	}
+}
+
+func Test_truncateUTF8(t *testing.T) {
+	s := "h€llo" // € is 3 bytes
+	for n, expected := range map[int]string{0: "", 1: "h", 2: "h", 3: "h", 4: "h€", 10: s} {
+		if got := truncateUTF8(s, n); got != expected {
+			t.Errorf("truncateUTF8(%q, %d) = %q, want %q", s, n, got, expected)
+		}
+	}
+}